@@ -0,0 +1,537 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	ZIP   string = "zip"
+	TAR   string = "tar"
+	TARGZ string = "tar.gz"
+)
+
+// archiveFormat returns the format of the archive at path, by its
+// extension, or an empty string if it isn't one.
+func archiveFormat(path string) string {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return ZIP
+	case strings.HasSuffix(name, ".tar"):
+		return TAR
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return TARGZ
+	default:
+		return ""
+	}
+}
+
+// archive is a zip or tar file whose entries are renamed. The entries are
+// added to a memFS under the path of the archive, so they are walked,
+// filtered and planned like files on disk. Only their metadata is read up
+// front, and their content when a template or hash needs it. The new
+// archive is written from the original one, entry by entry, so only the
+// names change.
+type archive struct {
+	path   string
+	format string
+	fsys   *memFS
+
+	mu sync.Mutex
+	// tar is the decompressed copy of a gzipped tar, made the first time the
+	// content of an entry is read, to read the entries at their offsets.
+	tar *os.File
+}
+
+// openArchive loads the entries of the archive. Entries whose names would
+// leave the archive are not loaded, and are written back as they are.
+func openArchive(path string) (*archive, error) {
+	a := &archive{path: filepath.Clean(path), format: archiveFormat(path), fsys: newMemFS()}
+	if err := a.fsys.MkdirAll(a.path, 0755); err != nil {
+		return nil, err
+	}
+	var err error
+	if a.format == ZIP {
+		err = a.loadZip()
+	} else {
+		err = a.loadTar()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read archive(%q): %w", path, err)
+	}
+	return a, nil
+}
+
+// close removes the decompressed copy of the archive, if one was made.
+func (a *archive) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tar == nil {
+		return nil
+	}
+	err := a.tar.Close()
+	_ = os.Remove(a.tar.Name())
+	a.tar = nil
+	return err
+}
+
+// entryPath returns where the entry is loaded, or false if it isn't.
+func (a *archive) entryPath(name string) (string, bool) {
+	name = path.Clean(strings.TrimSuffix(name, "/"))
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", false
+	}
+	return filepath.Join(a.path, filepath.FromSlash(name)), true
+}
+
+// load adds an entry to the memFS, whose content open reads. Links and other
+// entries that aren't regular files have none, but they take up their name
+// all the same.
+func (a *archive) load(name string, info os.FileInfo, open func() (contentReader, error)) error {
+	p, ok := a.entryPath(name)
+	switch {
+	case !ok:
+		return nil
+	case info.IsDir():
+		return a.fsys.MkdirAll(p, info.Mode().Perm())
+	case !info.Mode().IsRegular():
+		return a.fsys.AddFile(p, 0, info.Mode(), info.ModTime(), nil)
+	}
+	return a.fsys.AddFile(p, info.Size(), info.Mode().Perm(), info.ModTime(), open)
+}
+
+func (a *archive) loadZip() error {
+	r, err := zip.OpenReader(a.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if err := a.load(f.Name, f.FileInfo(), a.zipEntry(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipEntry returns how to read the content of the zip entry: straight from
+// the archive if it is stored, and inflated otherwise.
+func (a *archive) zipEntry(f *zip.File) func() (contentReader, error) {
+	name, method := f.Name, f.Method
+	compressed, size := int64(f.CompressedSize64), int64(f.UncompressedSize64)
+	offset, offsetErr := f.DataOffset()
+	return func() (contentReader, error) {
+		if offsetErr != nil {
+			return nil, fmt.Errorf("open entry(%q): %w", name, offsetErr)
+		}
+		file, err := os.Open(a.path)
+		if err != nil {
+			return nil, err
+		}
+		data := io.NewSectionReader(file, offset, compressed)
+		switch method {
+		case zip.Store:
+			return sectionContent{data, file}, nil
+		case zip.Deflate:
+			defer file.Close()
+			return spill(flate.NewReader(data), size)
+		default:
+			file.Close()
+			return nil, fmt.Errorf("open entry(%q): %w", name, zip.ErrAlgorithm)
+		}
+	}
+}
+
+func (a *archive) loadTar() error {
+	r, err := a.openTar()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// The offset of each entry in the tar is counted, so its content can be
+	// read from there.
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := a.load(hdr.Name, hdr.FileInfo(), a.tarEntry(hdr, index, counter.n)); err != nil {
+			return err
+		}
+	}
+}
+
+// openTar returns the tar stream of the archive, decompressed if gzipped.
+func (a *archive) openTar() (io.ReadCloser, error) {
+	f, err := os.Open(a.path)
+	if err != nil || a.format != TARGZ {
+		return f, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+// tarEntry returns how to read the content of the tar entry at offset: from
+// the archive, or its decompressed copy if it is gzipped. A sparse entry
+// isn't stored as it reads, so the tar is read again up to it.
+func (a *archive) tarEntry(hdr *tar.Header, index int, offset int64) func() (contentReader, error) {
+	size := hdr.Size
+	sparse := hdr.Typeflag == tar.TypeGNUSparse
+	for key := range hdr.PAXRecords {
+		sparse = sparse || strings.HasPrefix(key, "GNU.sparse.")
+	}
+	return func() (contentReader, error) {
+		if sparse {
+			return a.readTarEntry(index, size)
+		}
+		if a.format == TARGZ {
+			file, err := a.decompressed()
+			if err != nil {
+				return nil, err
+			}
+			// The copy is shared, and closed along with the archive.
+			return sectionContent{io.NewSectionReader(file, offset, size), io.NopCloser(nil)}, nil
+		}
+		file, err := os.Open(a.path)
+		if err != nil {
+			return nil, err
+		}
+		return sectionContent{io.NewSectionReader(file, offset, size), file}, nil
+	}
+}
+
+// readTarEntry reads the tar up to the entry at index, and returns its
+// content.
+func (a *archive) readTarEntry(index int, size int64) (contentReader, error) {
+	r, err := a.openTar()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for range index + 1 {
+		if _, err := tr.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return spill(tr, size)
+}
+
+// decompressed returns the decompressed copy of a gzipped tar, making it the
+// first time.
+func (a *archive) decompressed() (*os.File, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tar != nil {
+		return a.tar, nil
+	}
+	r, err := a.openTar()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	tmp, err := os.CreateTemp("", "omitter-*.tar")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("decompress archive(%q): %w", a.path, err)
+	}
+	a.tar = tmp
+	return tmp, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sectionContent reads part of a file.
+type sectionContent struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// maxSpilledInMemory is the size up to which spill keeps an entry in
+// memory.
+const maxSpilledInMemory = 32 << 20
+
+// spill reads the content of an entry that can't be read at random into
+// memory if it is small, and into a temp file removed once closed if not.
+func spill(r io.Reader, size int64) (contentReader, error) {
+	if size <= maxSpilledInMemory {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return memContent{bytes.NewReader(data)}, nil
+	}
+	tmp, err := os.CreateTemp("", "omitter-*")
+	if err != nil {
+		return nil, err
+	}
+	content := tempContent{tmp}
+	if _, err := io.Copy(tmp, r); err != nil {
+		content.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		content.Close()
+		return nil, err
+	}
+	return content, nil
+}
+
+type memContent struct {
+	*bytes.Reader
+}
+
+func (memContent) Close() error { return nil }
+
+type tempContent struct {
+	*os.File
+}
+
+func (t tempContent) Close() error {
+	err := t.File.Close()
+	_ = os.Remove(t.Name())
+	return err
+}
+
+// entryNames maps the names of the entries the plan renames to their new
+// names.
+func (a *archive) entryNames(pairs map[string]string) (map[string]string, error) {
+	names := make(map[string]string, len(pairs))
+	for src, dst := range pairs {
+		oldName, err := filepath.Rel(a.path, src)
+		if err != nil {
+			return nil, err
+		}
+		newName, err := filepath.Rel(a.path, dst)
+		if err != nil {
+			return nil, err
+		}
+		names[filepath.ToSlash(oldName)] = filepath.ToSlash(newName)
+	}
+	return names, nil
+}
+
+// renamed returns the new name of the entry, keeping a leading "./".
+func renamed(names map[string]string, name string) (string, bool) {
+	newName, ok := names[path.Clean(name)]
+	if ok && strings.HasPrefix(name, "./") {
+		newName = "./" + newName
+	}
+	return newName, ok
+}
+
+// write writes the archive with the entries renamed by pairs to dst, which
+// may be the archive itself. Unless overwrite is set, it fails if dst
+// exists. It returns how many entries were renamed.
+func (a *archive) write(ctx context.Context, pairs map[string]string, dst string, overwrite bool) (uint, error) {
+	names, err := a.entryNames(pairs)
+	if err != nil {
+		return 0, err
+	}
+	out, err := createPartial(osFS{}, dst)
+	if err != nil {
+		return 0, fmt.Errorf("create archive: %w", err)
+	}
+	defer out.discard()
+
+	var n uint
+	if a.format == ZIP {
+		n, err = a.writeZip(ctx, out, names)
+	} else {
+		n, err = a.writeTar(ctx, out, names)
+	}
+	if err != nil {
+		return 0, cmp.Or(stopped(ctx, RENAME, 0), err)
+	}
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return 0, fmt.Errorf("get file(%q) info: %w", a.path, err)
+	}
+	if err = out.Chmod(info.Mode()); err != nil {
+		return 0, fmt.Errorf("set file(%q) permissions: %w", dst, err)
+	}
+	if err = out.commit(overwrite); err != nil {
+		return 0, fmt.Errorf("create archive: %w", err)
+	}
+	return n, nil
+}
+
+// writeZip copies the entries without decompressing them, so they keep
+// their compression, times, attributes and comments.
+func (a *archive) writeZip(ctx context.Context, w io.Writer, names map[string]string) (uint, error) {
+	r, err := zip.OpenReader(a.path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	zw := zip.NewWriter(w)
+	if err := zw.SetComment(r.Comment); err != nil {
+		return 0, err
+	}
+	var n uint
+	for _, f := range r.File {
+		header := f.FileHeader
+		// The writer adds its own zip64 field where the sizes need it.
+		header.Extra = removeExtraField(header.Extra, zip64ExtraID)
+		if name, ok := renamed(names, f.Name); ok {
+			header.Name = name
+			// The Unicode path field would bring the old name back in the
+			// tools that read it.
+			header.Extra = removeExtraField(header.Extra, zipUnicodePathID)
+			if !isASCII(name) && utf8.ValidString(name) {
+				header.Flags |= zipUTF8Flag
+			}
+			n++
+		}
+		raw, err := f.OpenRaw()
+		if err != nil {
+			return n, fmt.Errorf("open entry(%q): %w", f.Name, err)
+		}
+		entry, err := zw.CreateRaw(&header)
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.Copy(entry, contextReader{ctx, raw}); err != nil {
+			return n, fmt.Errorf("copy entry(%q): %w", f.Name, err)
+		}
+	}
+	return n, zw.Close()
+}
+
+const (
+	zipUTF8Flag      = 0x800
+	zip64ExtraID     = 0x0001
+	zipUnicodePathID = 0x7075
+)
+
+// removeExtraField drops the fields of the id from the extra data of a zip
+// entry.
+func removeExtraField(extra []byte, id uint16) []byte {
+	var kept []byte
+	for len(extra) >= 4 {
+		size := int(binary.LittleEndian.Uint16(extra[2:])) + 4
+		if size > len(extra) {
+			break
+		}
+		if binary.LittleEndian.Uint16(extra) != id {
+			kept = append(kept, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return append(kept, extra...)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTar copies the entries with their headers, so they keep their
+// modes, owners, times, links and extended records. A gzipped archive is
+// compressed again with the header of the original.
+func (a *archive) writeTar(ctx context.Context, w io.Writer, names map[string]string) (uint, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if a.format == TARGZ {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		gzw := gzip.NewWriter(w)
+		gzw.Header = gz.Header
+		r, w = gz, gzw
+	}
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	var n uint
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if name, ok := renamed(names, hdr.Name); ok {
+			hdr.Name = name
+			delete(hdr.PAXRecords, "path")
+			// A longer name may need another format to be stored.
+			hdr.Format = tar.FormatUnknown
+			n++
+		}
+		if name, ok := renamed(names, hdr.Linkname); ok && hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = name
+			delete(hdr.PAXRecords, "linkpath")
+			hdr.Format = tar.FormatUnknown
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return n, fmt.Errorf("write entry(%q): %w", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, contextReader{ctx, tr}); err != nil {
+			return n, fmt.Errorf("copy entry(%q): %w", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return n, err
+	}
+	if gzw, ok := w.(*gzip.Writer); ok {
+		return n, gzw.Close()
+	}
+	return n, nil
+}
+
+var errMixedArchive = errors.New("an archive can't be given along with other paths")
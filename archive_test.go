@@ -0,0 +1,321 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var entryTime = time.Date(2023, 5, 6, 7, 8, 10, 0, time.UTC)
+
+func buildZip(t *testing.T, path string) {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	entries := []struct {
+		name   string
+		method uint16
+	}{
+		{"docs/aaa_report.txt", zip.Deflate},
+		{"aaa_photo.jpg", zip.Store},
+		{"keep.txt", zip.Deflate},
+	}
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method, Modified: entryTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bytes.Repeat([]byte(e.name), 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.SetComment("bundle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// renameInArchive plans stripping aaa_ from the entries and writes the archive to dst.
+func renameInArchive(t *testing.T, path, dst string) {
+	t.Helper()
+	arc, err := openArchive(path)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	cfg := config{options: fileOptions{path: arc.path, str: "aaa_"}, fsys: arc.fsys}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if len(result.pairs) != 2 {
+		t.Fatalf("expected 2 entries to rename, got %v", result.pairs)
+	}
+	n, err := arc.write(context.Background(), result.pairs, dst, dst == path)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 entries renamed, got %d and %v", n, err)
+	}
+}
+
+// TestRenameInZip verifies zip entries are renamed in place, keeping their compression and times.
+func TestRenameInZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	buildZip(t, path)
+	renameInArchive(t, path, path)
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	expected := []struct {
+		name, content string
+		method        uint16
+	}{
+		{"docs/report.txt", "docs/aaa_report.txt", zip.Deflate},
+		{"photo.jpg", "aaa_photo.jpg", zip.Store},
+		{"keep.txt", "keep.txt", zip.Deflate},
+	}
+	if len(r.File) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(r.File))
+	}
+	for i, e := range expected {
+		f := r.File[i]
+		if f.Name != e.name || f.Method != e.method {
+			t.Errorf("expected %s (method %d), got %s (method %d)", e.name, e.method, f.Name, f.Method)
+		}
+		if !f.Modified.Equal(entryTime) {
+			t.Errorf("%s: expected %s, got %s", f.Name, entryTime, f.Modified)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if !bytes.Equal(data, bytes.Repeat([]byte(e.content), 10)) {
+			t.Errorf("%s: content changed", f.Name)
+		}
+	}
+	if r.Comment != "bundle" {
+		t.Errorf("expected the comment to be kept, got %q", r.Comment)
+	}
+}
+
+// TestRenameInTarGz verifies tar entries keep their headers and the archive stays gzipped.
+func TestRenameInTarGz(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "bundle.tar.gz")
+	var b bytes.Buffer
+	gzw := gzip.NewWriter(&b)
+	gzw.Name = "bundle.tar"
+	tw := tar.NewWriter(gzw)
+	headers := []*tar.Header{
+		{Name: "./aaa_notes.txt", Mode: 0640, Uname: "alice", ModTime: entryTime, Typeflag: tar.TypeReg},
+		{Name: "./aaa_data.bin", Mode: 0600, ModTime: entryTime, Typeflag: tar.TypeReg},
+		{Name: "./link.bin", Linkname: "./aaa_data.bin", ModTime: entryTime, Typeflag: tar.TypeLink},
+	}
+	for _, hdr := range headers {
+		content := []byte(hdr.Name)
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(tempDir, "out.tgz")
+	renameInArchive(t, path, out)
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("expected a gzipped archive: %v", err)
+	}
+	if gz.Name != "bundle.tar" {
+		t.Errorf("expected the gzip header to be kept, got %q", gz.Name)
+	}
+	tr := tar.NewReader(gz)
+	expected := []struct{ name, linkname, content string }{
+		{"./notes.txt", "", "./aaa_notes.txt"},
+		{"./data.bin", "", "./aaa_data.bin"},
+		{"./link.bin", "./data.bin", ""},
+	}
+	for _, e := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("expected %s: %v", e.name, err)
+		}
+		if hdr.Name != e.name || hdr.Linkname != e.linkname {
+			t.Errorf("expected %s -> %q, got %s -> %q", e.name, e.linkname, hdr.Name, hdr.Linkname)
+		}
+		if !hdr.ModTime.Equal(entryTime) {
+			t.Errorf("%s: expected %s, got %s", hdr.Name, entryTime, hdr.ModTime)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil || string(data) != e.content {
+			t.Errorf("%s: expected %q, got %q and %v", hdr.Name, e.content, data, err)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected no more entries, got %v", err)
+	}
+}
+
+// buildTar writes a tar, gzipped if the path says so, of the entries with their contents.
+func buildTar(t *testing.T, path string, headers []*tar.Header, contents []string) {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.Writer = &b
+	gzw := gzip.NewWriter(&b)
+	if archiveFormat(path) == TARGZ {
+		w = gzw
+	}
+	tw := tar.NewWriter(w)
+	for i, hdr := range headers {
+		hdr.Size = int64(len(contents[i]))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w == gzw {
+		if err := gzw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestArchiveEntriesReadLazily verifies entries are loaded without their content, which is read when opened.
+func TestArchiveEntriesReadLazily(t *testing.T) {
+	tempDir := t.TempDir()
+	zipPath := filepath.Join(tempDir, "bundle.zip")
+	buildZip(t, zipPath)
+	contents := []string{"first entry", strings.Repeat("second entry", 100)}
+	headers := func() []*tar.Header {
+		return []*tar.Header{
+			{Name: "a.txt", Mode: 0644, ModTime: entryTime, Typeflag: tar.TypeReg},
+			{Name: "dir/b.txt", Mode: 0644, ModTime: entryTime, Typeflag: tar.TypeReg},
+		}
+	}
+	tarPath := filepath.Join(tempDir, "bundle.tar")
+	buildTar(t, tarPath, headers(), contents)
+	tgzPath := filepath.Join(tempDir, "bundle.tgz")
+	buildTar(t, tgzPath, headers(), contents)
+
+	expected := map[string]map[string]string{
+		zipPath: {
+			"docs/aaa_report.txt": strings.Repeat("docs/aaa_report.txt", 10),
+			"aaa_photo.jpg":       strings.Repeat("aaa_photo.jpg", 10),
+		},
+		tarPath: {"a.txt": contents[0], "dir/b.txt": contents[1]},
+		tgzPath: {"dir/b.txt": contents[1], "a.txt": contents[0]},
+	}
+	for path, entries := range expected {
+		arc, err := openArchive(path)
+		if err != nil {
+			t.Fatalf("open archive: %v", err)
+		}
+		for name, content := range entries {
+			p := filepath.Join(arc.path, filepath.FromSlash(name))
+			if e := arc.fsys.entries[p]; e == nil || e.data != nil || e.open == nil {
+				t.Errorf("%s: expected the content to be left in the archive", p)
+			}
+			if info, err := arc.fsys.Stat(p); err != nil || info.Size() != int64(len(content)) {
+				t.Errorf("%s: expected size %d, got %v and %v", p, len(content), info, err)
+			}
+			if data, err := arc.fsys.ReadFile(p); err != nil || string(data) != content {
+				t.Errorf("%s: expected %q, got %q and %v", p, content, data, err)
+			}
+		}
+		copied := arc.tar
+		if err := arc.close(); err != nil {
+			t.Fatal(err)
+		}
+		if (copied != nil) != (path == tgzPath) {
+			t.Errorf("%s: expected only a gzipped tar to be decompressed", path)
+		}
+		if copied != nil {
+			if _, err := os.Stat(copied.Name()); !os.IsNotExist(err) {
+				t.Errorf("expected the decompressed copy to be removed, got %v", err)
+			}
+		}
+	}
+}
+
+// TestArchiveLinkTakesName verifies an entry isn't renamed onto the name of a link.
+func TestArchiveLinkTakesName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.tar")
+	buildTar(t, path, []*tar.Header{
+		{Name: "aaa_x.txt", Mode: 0644, ModTime: entryTime, Typeflag: tar.TypeReg},
+		{Name: "x.txt", Linkname: "elsewhere", ModTime: entryTime, Typeflag: tar.TypeSymlink},
+	}, []string{"file", ""})
+
+	arc, err := openArchive(path)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	cfg := config{options: fileOptions{path: arc.path, str: "aaa_"}, fsys: arc.fsys}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	src := filepath.Join(arc.path, "aaa_x.txt")
+	if expected := filepath.Join(arc.path, "x_1.txt"); result.pairs[src] != expected {
+		t.Errorf("expected %s, got %v", expected, result.pairs)
+	}
+}
+
+// TestRemoveExtraField verifies only the fields of the id are dropped.
+func TestRemoveExtraField(t *testing.T) {
+	extra := []byte{
+		0x75, 0x70, 0x02, 0x00, 0xaa, 0xbb,
+		0x55, 0x54, 0x01, 0x00, 0xcc,
+	}
+	got := removeExtraField(extra, zipUnicodePathID)
+	if !bytes.Equal(got, extra[6:]) {
+		t.Errorf("expected %x, got %x", extra[6:], got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dhowden/tag"
+)
+
+// audioTags is the metadata read from a music file.
+type audioTags struct {
+	artist      string
+	albumArtist string
+	album       string
+	title       string
+	genre       string
+	year        int
+	track       int
+	disc        int
+}
+
+// errNoTags is returned for files without audio tags.
+var errNoTags = errors.New("no audio tags")
+
+// readAudio reads ID3v1 and ID3v2 tags, Vorbis comments of Ogg and FLAC
+// files, and the FLAC and MP4 metadata.
+func readAudio(fsys fileSystem, path string) (audioTags, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return audioTags{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		return audioTags{}, errNoTags
+	}
+	tags := audioTags{
+		artist:      m.Artist(),
+		albumArtist: m.AlbumArtist(),
+		album:       m.Album(),
+		title:       m.Title(),
+		genre:       m.Genre(),
+		year:        m.Year(),
+	}
+	tags.track, _ = m.Track()
+	tags.disc, _ = m.Disc()
+	return tags, nil
+}
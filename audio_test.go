@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildID3 lays out an ID3v2.3 tag of Latin-1 text frames, followed by a
+// stand-in for the audio.
+func buildID3(frames map[string]string) []byte {
+	var body []byte
+	for id, text := range frames {
+		body = append(body, id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(text)+1))
+		body = append(body, 0, 0, 0)
+		body = append(body, text...)
+	}
+	// The tag size is a synchsafe integer, 7 bits to a byte.
+	size := len(body)
+	b := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	b = append(b, body...)
+	return append(b, make([]byte, 128)...)
+}
+
+// buildFLAC lays out a FLAC stream with an empty STREAMINFO block and a
+// Vorbis comment block.
+func buildFLAC(comments ...string) []byte {
+	var block []byte
+	block = binary.LittleEndian.AppendUint32(block, 0)
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, c := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(c)))
+		block = append(block, c...)
+	}
+	b := []byte("fLaC")
+	b = append(b, 0, 0, 0, 34)
+	b = append(b, make([]byte, 34)...)
+	b = append(b, 0x80|4, byte(len(block)>>16), byte(len(block)>>8), byte(len(block)))
+	return append(b, block...)
+}
+
+// TestReadAudio verifies tags are read from ID3 and FLAC files.
+func TestReadAudio(t *testing.T) {
+	tempDir := t.TempDir()
+	cases := map[string][]byte{
+		"song.mp3": buildID3(map[string]string{
+			"TPE1": "Nina Simone", "TALB": "Pastel Blues", "TIT2": "Sinnerman",
+			"TRCK": "10/10", "TYER": "1965",
+		}),
+		"song.flac": buildFLAC(
+			"ARTIST=Nina Simone", "ALBUM=Pastel Blues", "TITLE=Sinnerman",
+			"TRACKNUMBER=10", "DATE=1965",
+		),
+	}
+	for name, content := range cases {
+		path := createTempFile(t, tempDir, name, string(content))
+		tags, err := readAudio(osFS{}, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		expected := audioTags{
+			artist: "Nina Simone", album: "Pastel Blues", title: "Sinnerman",
+			year: 1965, track: 10,
+		}
+		if tags != expected {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, tags)
+		}
+	}
+
+	path := createTempFile(t, tempDir, "notes.txt", "not a song at all")
+	if _, err := readAudio(osFS{}, path); err != errNoTags {
+		t.Errorf("notes.txt: expected %v, got %v", errNoTags, err)
+	}
+}
+
+// TestWalkerWithAudioTemplate verifies songs are sorted into artist and album folders.
+func TestWalkerWithAudioTemplate(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	song := createTempFile(t, srcDir, "track01.flac", string(buildFLAC(
+		"ARTIST=AC/DC", "ALBUM=Back in Black", "TITLE=Hells Bells", "TRACKNUMBER=1",
+	)))
+	untagged := createTempFile(t, srcDir, "demo.mp3", "no tags")
+
+	chain, err := parseOperations([]string{
+		"template:{artist:Unknown} - {album:Unknown}/{track:02} {title:Untitled}{ext}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: srcDir, output: dstDir}, chain: chain}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+
+	expected := map[string]string{
+		song:     filepath.Join(dstDir, "AC_DC - Back in Black", "01 Hells Bells.flac"),
+		untagged: filepath.Join(dstDir, "Unknown - Unknown", "00 Untitled.mp3"),
+	}
+	for path, newPath := range expected {
+		if result.pairs[path] != newPath {
+			t.Errorf("expected %q, got %q", newPath, result.pairs[path])
+		}
+	}
+
+	if _, err := copyAction(context.Background(), result.pairs, actionOptions{}); err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+	if _, err := os.Stat(expected[song]); err != nil {
+		t.Errorf("expected the album folder to be created: %v", err)
+	}
+}
+
+// TestWalkerKeepTree verifies the source folders are kept under the output dir.
+func TestWalkerKeepTree(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file1 := createTempFile(t, filepath.Join(srcDir, "a", "b"), "example_target.txt", "dummy")
+	file2 := createTempFile(t, srcDir, "top_target.txt", "dummy")
+
+	cfg := config{
+		options: fileOptions{path: srcDir, str: "_target", output: dstDir, keepTree: true},
+	}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	expected := map[string]string{
+		file1: filepath.Join(dstDir, "a", "b", "example.txt"),
+		file2: filepath.Join(dstDir, "top.txt"),
+	}
+	for path, newPath := range expected {
+		if result.pairs[path] != newPath {
+			t.Errorf("expected %q, got %q", newPath, result.pairs[path])
+		}
+	}
+}
+
+// TestWalkerRejectsEscapingNames verifies names can't climb out of the target directory.
+func TestWalkerRejectsEscapingNames(t *testing.T) {
+	tempDir := t.TempDir()
+	createTempFile(t, tempDir, "a.txt", "dummy")
+
+	chain, err := parseOperations([]string{"template:../{name}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir}, chain: chain}
+	if _, err := walker(context.Background(), cfg, nil); err == nil {
+		t.Error("expected an error")
+	}
+}
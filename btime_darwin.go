@@ -0,0 +1,17 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// birthTime returns when the file was created.
+func birthTime(_ string, info fs.FileInfo) (time.Time, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, errors.New("not supported")
+	}
+	return time.Unix(st.Birthtimespec.Unix()), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// birthTime returns when the file was created.
+func birthTime(path string, _ fs.FileInfo) (time.Time, error) {
+	var stx unix.Statx_t
+	err := unix.Statx(
+		unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &stx,
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if stx.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}, errors.New("not recorded by the file system")
+	}
+	return time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec)), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// birthTime returns when the file was created.
+func birthTime(_ string, _ fs.FileInfo) (time.Time, error) {
+	return time.Time{}, errors.New("not supported on this platform")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// cancelledError is returned when the context ends part way through a
+// stage. The results returned with it are what the stage got through, and
+// it unwraps to the cause, so errors.Is(err, context.Canceled) holds.
+type cancelledError struct {
+	// stage is walk, or the action that was applied.
+	stage string
+	// done is how many files the stage got through.
+	done  uint
+	cause error
+}
+
+func (e *cancelledError) Error() string {
+	return fmt.Sprintf("%s stopped after %d file(s): %v", e.stage, e.done, e.cause)
+}
+
+func (e *cancelledError) Unwrap() error {
+	return e.cause
+}
+
+// stopped returns a cancelledError if ctx is done, or nil.
+func stopped(ctx context.Context, stage string, done uint) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return &cancelledError{stage: stage, done: done, cause: ctx.Err()}
+}
+
+// contextReader fails reads once ctx is done, so long copies and hashes
+// stop part way.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
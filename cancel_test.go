@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWalkerStopsWhenCancelled verifies a cancelled walk reports where it stopped.
+func TestWalkerStopsWhenCancelled(t *testing.T) {
+	tempDir := t.TempDir()
+	createTempFile(t, tempDir, "aaa_1.txt", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := config{options: fileOptions{path: tempDir, str: "aaa"}}
+	result, err := walker(ctx, cfg, nil)
+	var cancelled *cancelledError
+	if !errors.As(err, &cancelled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+	if cancelled.stage != "walk" || cancelled.done != 0 || len(result.pairs) != 0 {
+		t.Errorf("expected an empty walk, got %v and %v", err, result.pairs)
+	}
+}
+
+// TestActionReportsPartialResults verifies an action stopped part way says how far it got.
+func TestActionReportsPartialResults(t *testing.T) {
+	tempDir := t.TempDir()
+	src := createTempFile(t, tempDir, "a.txt", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err := moveAction(ctx, map[string]string{src: filepath.Join(tempDir, "b.txt")}, actionOptions{})
+	var cancelled *cancelledError
+	if !errors.As(err, &cancelled) {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+	if cancelled.stage != MOVE || cancelled.done != n {
+		t.Errorf("expected %s after %d file(s), got %v", MOVE, n, err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("expected the source to be kept, error: %v", err)
+	}
+}
+
+// TestCopyStopsWhenCancelled verifies long copies and hashes stop part way, leaving nothing behind.
+func TestCopyStopsWhenCancelled(t *testing.T) {
+	tempDir := t.TempDir()
+	src := createTempFile(t, tempDir, "big.bin", string(make([]byte, 1<<20)))
+	dst := filepath.Join(tempDir, "copy.bin")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := copyFile(ctx, src, dst, actionOptions{reflink: NEVER}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the source to be left, got %d file(s)", len(entries))
+	}
+
+	if _, err := hashFile(ctx, osFS{}, src, []string{BLAKE3}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
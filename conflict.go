@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	SKIP        string = "skip"
+	OVERWRITE   string = "overwrite"
+	SUFFIX      string = "suffix"
+	FAIL        string = "fail"
+	KEEP_NEWER  string = "keep-newer"
+	KEEP_LARGER string = "keep-larger"
+)
+
+const defaultSuffixFormat = "_%d"
+
+const (
+	LINK   string = "link"
+	REPORT string = "report"
+)
+
+func getDedupeMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return "", nil
+	case SKIP, LINK, REPORT:
+		return strings.ToLower(mode), nil
+	default:
+		return "", fmt.Errorf("unknown dedupe mode %q", mode)
+	}
+}
+
+func getConflictPolicy(policy string) (string, error) {
+	switch strings.ToLower(policy) {
+	case "", SUFFIX:
+		return SUFFIX, nil
+	case SKIP, OVERWRITE, FAIL, KEEP_NEWER, KEEP_LARGER:
+		return strings.ToLower(policy), nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", policy)
+	}
+}
+
+// validateSuffixFormat makes sure the format holds exactly one number, like
+// "_%d", " (%d)" or "-v%d".
+func validateSuffixFormat(format string) error {
+	if strings.Count(format, "%d") != 1 || strings.Count(format, "%") != 1 {
+		return fmt.Errorf("suffix format %q must hold exactly one %%d", format)
+	}
+	return nil
+}
+
+// conflictResolver applies the conflict policy while the plan is built. A
+// planned path conflicts when another file in the plan already targets it, or
+// when it exists on disk and isn't leaving.
+type conflictResolver struct {
+	fsys   fileSystem
+	policy string
+	format string
+	// dedupe is how duplicates are handled. When empty, identical files
+	// conflict like any others.
+	dedupe string
+	// targets maps each planned destination back to its source.
+	targets map[string]string
+	// sources keeps each planned source by path, to compare their digests.
+	sources map[string]*source
+	// leaving holds the files that are renamed or moved away, and so don't
+	// take up their path. If one of them ends up staying, say by the skip
+	// policy, settle plans the file planned over it again.
+	leaving map[string]bool
+	// wanted maps each source to the destination it was added with, before
+	// any suffix.
+	wanted map[string]string
+	// result is the plan the files are resolved into. Every walk given the
+	// resolver adds to it, so the files of several paths are checked
+	// against each other.
+	result *plan
+}
+
+func newConflictResolver(fsys fileSystem, policy, format, dedupe string) *conflictResolver {
+	result := newPlan()
+	if policy == "" {
+		policy = SUFFIX
+	}
+	if format == "" {
+		format = defaultSuffixFormat
+	}
+	return &conflictResolver{
+		fsys:    fsys,
+		policy:  policy,
+		format:  format,
+		dedupe:  dedupe,
+		targets: make(map[string]string),
+		sources: make(map[string]*source),
+		leaving: make(map[string]bool),
+		wanted:  make(map[string]string),
+		result:  &result,
+	}
+}
+
+// onDisk reports whether path is taken by a file that stays. A file that
+// is leaving doesn't take the path of its own name in another case, which a
+// case-insensitive file system finds it under too.
+func (r *conflictResolver) onDisk(file *source, path string) bool {
+	_, err := r.fsys.Lstat(path)
+	if err != nil || r.leaving[path] {
+		return false
+	}
+	return !r.leaving[file.path] || !caseVariant(r.fsys, file.path, path)
+}
+
+// add plans moving file to dst, resolving any conflict on the way. It may
+// drop file, or an earlier pair that loses to it, from the plan.
+//
+// A byte-identical file already at dst is not a conflict but a duplicate.
+// It is left alone, or in link mode planned under a suffixed name and
+// hard-linked rather than written.
+func (r *conflictResolver) add(ctx context.Context, file *source, dst string) error {
+	p := r.result
+	pairs := p.pairs
+	src := file.path
+	r.wanted[src] = dst
+	if dst == src {
+		// The file keeps its name, so there is nothing to plan.
+		return nil
+	}
+	rival, planned := r.targets[dst]
+	onDisk := r.onDisk(file, dst)
+	if !planned && !onDisk {
+		r.plan(pairs, file, dst)
+		return nil
+	}
+	if !planned {
+		rival = dst
+	}
+
+	dup, err := r.duplicate(ctx, file, dst)
+	if err != nil {
+		return err
+	}
+	if dup {
+		if r.dedupe != LINK {
+			p.duplicates[src] = dst
+			return nil
+		}
+		candidate, err := r.suffixed(ctx, file, dst)
+		if err != nil || candidate == "" {
+			return err
+		}
+		r.plan(pairs, file, candidate)
+		p.links[candidate] = dst
+		return nil
+	}
+
+	switch r.policy {
+	case SKIP:
+		return nil
+	case FAIL:
+		if planned {
+			return fmt.Errorf("conflict: %q and %q both resolve to %q", rival, src, dst)
+		}
+		return fmt.Errorf("conflict: %q already exists", dst)
+	case OVERWRITE:
+		r.replace(pairs, file, dst, planned)
+		return nil
+	case KEEP_NEWER, KEEP_LARGER:
+		wins, err := r.wins(src, rival)
+		if err != nil {
+			return err
+		}
+		if wins {
+			r.replace(pairs, file, dst, planned)
+		}
+		return nil
+	default:
+		candidate, err := r.suffixed(ctx, file, dst)
+		if err != nil || candidate == "" {
+			return err
+		}
+		r.plan(pairs, file, candidate)
+		return nil
+	}
+}
+
+// settle plans again the pairs whose destination is taken by a file that was
+// thought to be leaving, but stays as its own pair was dropped. As that may
+// drop more pairs, it goes on until every file left marked as leaving does.
+func (r *conflictResolver) settle(ctx context.Context) error {
+	for {
+		var stuck []string
+		for path := range r.leaving {
+			if _, ok := r.result.pairs[path]; ok {
+				continue
+			}
+			delete(r.leaving, path)
+			if src, ok := r.targets[path]; ok {
+				stuck = append(stuck, src)
+			}
+		}
+		if len(stuck) == 0 {
+			return nil
+		}
+		slices.Sort(stuck)
+		for _, src := range stuck {
+			r.unplan(src)
+		}
+		for _, src := range stuck {
+			if err := r.add(ctx, r.sources[src], r.wanted[src]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unplan drops the pair of src, keeping its source to be added again.
+func (r *conflictResolver) unplan(src string) {
+	dst := r.result.pairs[src]
+	delete(r.result.pairs, src)
+	delete(r.result.links, dst)
+	delete(r.targets, dst)
+}
+
+func (r *conflictResolver) plan(pairs map[string]string, file *source, dst string) {
+	pairs[file.path] = dst
+	r.targets[dst] = file.path
+	r.sources[file.path] = file
+}
+
+// replace plans file to dst in place of whatever was there, leaving the
+// source of an earlier pair untouched.
+func (r *conflictResolver) replace(pairs map[string]string, file *source, dst string, planned bool) {
+	if planned {
+		rival := r.targets[dst]
+		delete(pairs, rival)
+		delete(r.sources, rival)
+	}
+	r.plan(pairs, file, dst)
+}
+
+// duplicate reports whether the file taking path, planned or on disk, has the
+// same content as file. Sizes are compared first, then digests, reusing those
+// the chain read. Without a dedupe mode there are no duplicates.
+func (r *conflictResolver) duplicate(ctx context.Context, file *source, path string) (bool, error) {
+	if r.dedupe == "" {
+		return false, nil
+	}
+	other := &source{path: path, fsys: r.fsys}
+	if rival, planned := r.targets[path]; planned {
+		other = r.sources[rival]
+	}
+
+	info, err := r.fsys.Stat(file.path)
+	if err != nil {
+		return false, fmt.Errorf("get file(%q) info: %w", file.path, err)
+	}
+	otherInfo, err := r.fsys.Stat(other.path)
+	if err != nil {
+		return false, fmt.Errorf("get file(%q) info: %w", other.path, err)
+	}
+	if info.Size() != otherInfo.Size() {
+		return false, nil
+	}
+
+	algorithm, ok := strongestHash(file.digests)
+	if !ok {
+		algorithm = SHA256
+	}
+	digest, err := sourceDigest(ctx, file, algorithm)
+	if err != nil {
+		return false, err
+	}
+	otherDigest, err := sourceDigest(ctx, other, algorithm)
+	if err != nil {
+		return false, err
+	}
+	return digest == otherDigest, nil
+}
+
+// sourceDigest returns the digest of the file, computing and keeping it if it
+// isn't known yet.
+func sourceDigest(ctx context.Context, file *source, algorithm string) (string, error) {
+	if digest, ok := file.digests[algorithm]; ok {
+		return digest, nil
+	}
+	digests, err := hashFile(ctx, orOS(file.fsys), file.path, []string{algorithm})
+	if err != nil {
+		return "", err
+	}
+	if file.digests == nil {
+		file.digests = make(map[string]string)
+	}
+	file.digests[algorithm] = digests[algorithm]
+	return digests[algorithm], nil
+}
+
+// wins reports whether src should be kept over rival, by the policy.
+func (r *conflictResolver) wins(src, rival string) (bool, error) {
+	srcInfo, err := r.fsys.Stat(src)
+	if err != nil {
+		return false, fmt.Errorf("get file(%q) info: %w", src, err)
+	}
+	rivalInfo, err := r.fsys.Stat(rival)
+	if err != nil {
+		return false, fmt.Errorf("get file(%q) info: %w", rival, err)
+	}
+	if r.policy == KEEP_LARGER {
+		return srcInfo.Size() > rivalInfo.Size(), nil
+	}
+	return srcInfo.ModTime().After(rivalInfo.ModTime()), nil
+}
+
+// suffixed returns the first variant of dst, numbered by the suffix format,
+// that is neither planned nor on disk. It returns an empty path if one of the
+// variants already holds a duplicate of file, and records it as such, or if
+// the first free one is the path of file itself, which then keeps its name.
+func (r *conflictResolver) suffixed(ctx context.Context, file *source, dst string) (string, error) {
+	dir, name := filepath.Split(dst)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for count := 1; ; count++ {
+		candidate := filepath.Join(dir, stem+fmt.Sprintf(r.format, count)+ext)
+		if candidate == file.path {
+			return "", nil
+		}
+		_, planned := r.targets[candidate]
+		if !planned && !r.onDisk(file, candidate) {
+			return candidate, nil
+		}
+		dup, err := r.duplicate(ctx, file, candidate)
+		if err != nil {
+			return "", err
+		}
+		if dup {
+			r.result.duplicates[file.path] = candidate
+			return "", nil
+		}
+	}
+}
+
+// allowsOverwrite reports whether the policy consents to replacing files that
+// already exist at the destination.
+func allowsOverwrite(policy string) bool {
+	switch policy {
+	case OVERWRITE, KEEP_NEWER, KEEP_LARGER:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collidingConfig returns a config that turns "a_x.txt" and "a_y.txt" into "a.txt".
+func collidingConfig(t *testing.T, dir, policy string) config {
+	t.Helper()
+	chain, err := parseOperations([]string{"regex:_[xy]="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config{
+		options: fileOptions{path: dir, onConflict: policy},
+		chain:   chain,
+	}
+}
+
+// TestConflictSuffixFormat verifies the suffix policy numbers names with the given format.
+func TestConflictSuffixFormat(t *testing.T) {
+	tempDir := t.TempDir()
+	createTempFile(t, tempDir, "a_x.txt", "dummy")
+	createTempFile(t, tempDir, "a_y.txt", "dummy")
+
+	cfg := collidingConfig(t, tempDir, SUFFIX)
+	cfg.options.suffixFormat = " (%d)"
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+
+	newNames := make(map[string]bool)
+	for _, newPath := range pairs {
+		newNames[filepath.Base(newPath)] = true
+	}
+	if !newNames["a.txt"] || !newNames["a (1).txt"] {
+		t.Errorf("expected 'a.txt' and 'a (1).txt', got %v", newNames)
+	}
+}
+
+// TestConflictWithExistingFile verifies pure removal no longer silently collides with a file on disk.
+func TestConflictWithExistingFile(t *testing.T) {
+	tempDir := t.TempDir()
+	file1 := createTempFile(t, tempDir, "a_x.txt", "dummy")
+	createTempFile(t, tempDir, "a.txt", "existing")
+
+	cases := map[string]string{
+		SUFFIX:    "a_1.txt",
+		OVERWRITE: "a.txt",
+		SKIP:      "",
+	}
+	for policy, expected := range cases {
+		result, err := walker(context.Background(), collidingConfig(t, tempDir, policy), nil)
+		if err != nil {
+			t.Fatalf("%s: walker error: %v", policy, err)
+		}
+		pairs := result.pairs
+		newPath, ok := pairs[file1]
+		switch {
+		case expected == "" && ok:
+			t.Errorf("%s: did not expect file %s in pairs", policy, file1)
+		case expected != "" && filepath.Base(newPath) != expected:
+			t.Errorf("%s: expected %q, got %q", policy, expected, newPath)
+		}
+	}
+
+	if _, err := walker(context.Background(), collidingConfig(t, tempDir, FAIL), nil); err == nil {
+		t.Errorf("%s: expected an error", FAIL)
+	}
+}
+
+// TestConflictKeepNewer verifies keep-newer keeps the most recently modified file.
+func TestConflictKeepNewer(t *testing.T) {
+	tempDir := t.TempDir()
+	older := createTempFile(t, tempDir, "a_x.txt", "dummy")
+	newer := createTempFile(t, tempDir, "a_y.txt", "dummy")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(older, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := walker(context.Background(), collidingConfig(t, tempDir, KEEP_NEWER), nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+	if len(pairs) != 1 {
+		t.Fatalf("expected 1 file to be processed, got %v", pairs)
+	}
+	if _, ok := pairs[newer]; !ok {
+		t.Errorf("expected newer file %s to be kept, got %v", newer, pairs)
+	}
+}
+
+// TestConflictKeepLarger verifies keep-larger keeps the larger file.
+func TestConflictKeepLarger(t *testing.T) {
+	tempDir := t.TempDir()
+	larger := createTempFile(t, tempDir, "a_x.txt", "larger content")
+	createTempFile(t, tempDir, "a_y.txt", "small")
+
+	result, err := walker(context.Background(), collidingConfig(t, tempDir, KEEP_LARGER), nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+	if len(pairs) != 1 {
+		t.Fatalf("expected 1 file to be processed, got %v", pairs)
+	}
+	if _, ok := pairs[larger]; !ok {
+		t.Errorf("expected larger file %s to be kept, got %v", larger, pairs)
+	}
+}
+
+// TestWalkerWithOutput verifies files are planned into the output dir itself.
+func TestWalkerWithOutput(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	file1 := createTempFile(t, srcDir, "example_target.txt", "dummy")
+
+	cfg := config{
+		options: fileOptions{path: srcDir, str: "_target", output: dstDir},
+	}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+	expected := filepath.Join(dstDir, "example.txt")
+	if pairs[file1] != expected {
+		t.Errorf("expected %q, got %q", expected, pairs[file1])
+	}
+}
+
+// TestConflictWithStayingSource verifies a file whose pair is dropped keeps its path from the others.
+func TestConflictWithStayingSource(t *testing.T) {
+	// a.txt would take the name of c.txt, which stays, and a_x.txt the
+	// name of a.txt.
+	chain, err := parseOperations([]string{"replace:a.=c.", "replace:a_x=a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []string{SKIP, KEEP_LARGER} {
+		tempDir := t.TempDir()
+		createTempFile(t, tempDir, "a.txt", "first")
+		createTempFile(t, tempDir, "a_x.txt", "2nd")
+		createTempFile(t, tempDir, "c.txt", "the largest")
+
+		cfg := config{options: fileOptions{path: tempDir, onConflict: policy}, chain: chain}
+		result, err := walker(context.Background(), cfg, nil)
+		if err != nil {
+			t.Fatalf("%s: walker error: %v", policy, err)
+		}
+		if len(result.pairs) != 0 {
+			t.Errorf("%s: expected every file to stay, got %v", policy, result.pairs)
+		}
+	}
+}
+
+// TestConflictAcrossPaths verifies files of different paths sharing a resolver don't collide.
+func TestConflictAcrossPaths(t *testing.T) {
+	first, second, dstDir := t.TempDir(), t.TempDir(), t.TempDir()
+	file1 := createTempFile(t, first, "a_x.txt", "one")
+	file2 := createTempFile(t, second, "a_y.txt", "two")
+
+	cfg := collidingConfig(t, first, SUFFIX)
+	cfg.options.output = dstDir
+	cfg.resolver = newConflictResolver(osFS{}, SUFFIX, "", "")
+	var result plan
+	for _, path := range []string{first, second} {
+		cfg.options.path = path
+		found, err := walker(context.Background(), cfg, nil)
+		if err != nil {
+			t.Fatalf("walker error: %v", err)
+		}
+		result = found
+	}
+	expected := map[string]string{file1: filepath.Join(dstDir, "a.txt"), file2: filepath.Join(dstDir, "a_1.txt")}
+	if len(result.pairs) != 2 || result.pairs[file1] != expected[file1] || result.pairs[file2] != expected[file2] {
+		t.Errorf("expected %v, got %v", expected, result.pairs)
+	}
+	if len(result.order) != 2 {
+		t.Errorf("expected both files in the order, got %v", result.order)
+	}
+}
+
+// TestValidateSuffixFormat verifies the suffix format holds exactly one number.
+func TestValidateSuffixFormat(t *testing.T) {
+	for _, format := range []string{"_%d", " (%d)", "-v%d"} {
+		if err := validateSuffixFormat(format); err != nil {
+			t.Errorf("%q: unexpected error: %v", format, err)
+		}
+	}
+	for _, format := range []string{"", "_", "%d-%d", "%s", "%d%%"} {
+		if err := validateSuffixFormat(format); err == nil {
+			t.Errorf("%q: expected an error", format)
+		}
+	}
+}
+
+// TestDedupeSkip verifies byte-identical files already in the output are left alone.
+func TestDedupeSkip(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	same := createTempFile(t, srcDir, "a_x.txt", "same")
+	different := createTempFile(t, srcDir, "b_x.txt", "diff")
+	createTempFile(t, dstDir, "a.txt", "same")
+	createTempFile(t, dstDir, "b.txt", "same")
+
+	cfg := collidingConfig(t, srcDir, SUFFIX)
+	cfg.options.output = dstDir
+	cfg.options.dedupe = SKIP
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if _, ok := result.pairs[same]; ok {
+		t.Errorf("did not expect duplicate %s in pairs", same)
+	}
+	if result.duplicates[same] != filepath.Join(dstDir, "a.txt") {
+		t.Errorf("expected %s to be a duplicate of a.txt, got %v", same, result.duplicates)
+	}
+	if filepath.Base(result.pairs[different]) != "b_1.txt" {
+		t.Errorf("expected same sized file %s to be suffixed, got %v", different, result.pairs)
+	}
+}
+
+// TestDedupeLink verifies duplicates are hard-linked instead of copied.
+func TestDedupeLink(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	createTempFile(t, srcDir, "a_x.txt", "same")
+	createTempFile(t, srcDir, "a_y.txt", "same")
+
+	cfg := collidingConfig(t, srcDir, SUFFIX)
+	cfg.options.output = dstDir
+	cfg.options.dedupe = LINK
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if len(result.pairs) != 2 || len(result.links) != 1 {
+		t.Fatalf("expected 2 files with 1 link, got %v and %v", result.pairs, result.links)
+	}
+
+	n, err := copyAction(context.Background(), result.pairs, actionOptions{links: result.links})
+	if err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 files copied, got %d", n)
+	}
+	first, err := os.Stat(filepath.Join(dstDir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.Stat(filepath.Join(dstDir, "a_1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(first, second) {
+		t.Error("expected the duplicate to be a hard link")
+	}
+}
+
+// foldFS is the local disk looked up without regard to case, like the
+// default file systems of macOS and Windows.
+type foldFS struct {
+	osFS
+}
+
+func (f foldFS) Lstat(name string) (fs.FileInfo, error) {
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.Name(), filepath.Base(name)) {
+			return os.Lstat(filepath.Join(filepath.Dir(name), e.Name()))
+		}
+	}
+	return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+}
+
+// TestConflictCaseOnlyRename verifies a file renamed only in case doesn't conflict with itself on a case-insensitive file system, while another file does.
+func TestConflictCaseOnlyRename(t *testing.T) {
+	tempDir := t.TempDir()
+	upper := createTempFile(t, tempDir, "A.txt", "a")
+	other := createTempFile(t, tempDir, "B_x.txt", "b")
+	createTempFile(t, tempDir, "b.txt", "taken")
+
+	chain, err := parseOperations([]string{"case:lower", "remove:_x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir}, chain: chain, fsys: foldFS{}}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if expected := filepath.Join(tempDir, "a.txt"); result.pairs[upper] != expected {
+		t.Errorf("expected %s, got %s", expected, result.pairs[upper])
+	}
+	if expected := filepath.Join(tempDir, "b_1.txt"); result.pairs[other] != expected {
+		t.Errorf("expected %s, got %s", expected, result.pairs[other])
+	}
+}
+
+// TestConflictSuffixIsOwnName verifies a file whose suffixed name is its own keeps it, rather than being planned onto itself.
+func TestConflictSuffixIsOwnName(t *testing.T) {
+	tempDir := t.TempDir()
+	a := createTempFile(t, tempDir, "a.txt", "a")
+	a1 := createTempFile(t, tempDir, "a_1.txt", "a1")
+
+	cfg := config{options: fileOptions{path: tempDir, str: "_1"}}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if len(result.pairs) != 0 {
+		t.Fatalf("expected nothing to rename, got %v", result.pairs)
+	}
+	if _, err := renameAction(context.Background(), result.pairs, actionOptions{order: result.order}); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{a: "a", a1: "a1"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("%s: expected %q, got %q and %v", path, content, data, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	AUTO   string = "auto"
+	ALWAYS string = "always"
+	NEVER  string = "never"
+)
+
+func getReflinkMode(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", AUTO:
+		return AUTO, nil
+	case ALWAYS, NEVER:
+		return strings.ToLower(mode), nil
+	default:
+		return "", fmt.Errorf("unknown reflink mode %q", mode)
+	}
+}
+
+// copyData copies the content of in to out by the fastest way the platform
+// and the file system offer: a copy-on-write clone, unless reflink is never,
+// then an in-kernel copy, and a buffered copy last. With reflink always, it
+// fails rather than copy the data when the file can't be cloned. Sparse
+// files are copied segment by segment, keeping their holes.
+//
+// copy_file_range may clone on its own, so it is skipped too when reflink
+// is never, as coreutils does.
+//
+// The copy stops part way once ctx is done. Files that aren't on the local
+// disk always get a buffered copy.
+func copyData(ctx context.Context, outFile writeFile, inFile readFile, reflink string) error {
+	out, outOK := outFile.(*os.File)
+	in, inOK := inFile.(*os.File)
+	if !outOK || !inOK {
+		if reflink == ALWAYS {
+			return fmt.Errorf("reflink: %w", errors.ErrUnsupported)
+		}
+		_, err := io.Copy(outFile, contextReader{ctx, inFile})
+		return err
+	}
+	if reflink != NEVER {
+		err := cloneFile(out, in)
+		if err == nil {
+			return nil
+		}
+		if reflink == ALWAYS {
+			return fmt.Errorf("reflink: %w", err)
+		}
+	}
+	if info, err := in.Stat(); err == nil && isSparse(info) {
+		err := sparseCopy(ctx, out, in, info.Size())
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	if reflink != NEVER {
+		err := copyFileRange(ctx, out, in)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	err := sendFile(ctx, out, in)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	// Hide ReadFrom, so io.Copy doesn't take the kernel paths again.
+	_, err = io.Copy(struct{ io.Writer }{out}, contextReader{ctx, in})
+	return err
+}
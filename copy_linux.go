@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes out share the data of in, by the FICLONE ioctl that btrfs,
+// XFS and other copy-on-write file systems support.
+func cloneFile(out, in *os.File) error {
+	err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	switch {
+	case err == nil:
+		return nil
+	case unsupported(err):
+		return errors.ErrUnsupported
+	default:
+		return err
+	}
+}
+
+func copyFileRange(ctx context.Context, out, in *os.File) error {
+	return kernelCopy(ctx, func() (int, error) {
+		return unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, kernelChunk, 0)
+	})
+}
+
+func sendFile(ctx context.Context, out, in *os.File) error {
+	return kernelCopy(ctx, func() (int, error) {
+		return unix.Sendfile(int(out.Fd()), int(in.Fd()), nil, kernelChunk)
+	})
+}
+
+// kernelChunk is how much a single in-kernel copy call moves, which bounds
+// how long a copy runs on after its context ends.
+const kernelChunk = 1 << 24
+
+// kernelCopy calls copy until it copies nothing more, moving the offsets of
+// both files along. It returns errors.ErrUnsupported if the first call
+// can't copy between the files, so the caller may try another way from
+// the start.
+func kernelCopy(ctx context.Context, copy func() (int, error)) error {
+	copied := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := copy()
+		switch {
+		case err == nil && n == 0:
+			return nil
+		case err == nil:
+			copied = true
+		case errors.Is(err, unix.EINTR):
+		case !copied && unsupported(err):
+			return errors.ErrUnsupported
+		default:
+			return err
+		}
+	}
+}
+
+// unsupported reports whether err says the kernel or the file system can't
+// do the call, or not between these two files.
+func unsupported(err error) bool {
+	for _, errno := range []unix.Errno{
+		unix.ENOSYS, unix.EOPNOTSUPP, unix.ENOTTY, unix.EXDEV, unix.EINVAL, unix.EBADF, unix.EPERM,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
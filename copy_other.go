@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+func cloneFile(out, in *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func copyFileRange(ctx context.Context, out, in *os.File) error {
+	return errors.ErrUnsupported
+}
+
+func sendFile(ctx context.Context, out, in *os.File) error {
+	return errors.ErrUnsupported
+}
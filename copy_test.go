@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCopyDataModes verifies every reflink mode copies the data, or with
+// always, fails cleanly where the file system can't clone.
+func TestCopyDataModes(t *testing.T) {
+	tempDir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	src := createTempFile(t, tempDir, "src.img", string(content))
+
+	for _, mode := range []string{AUTO, NEVER, ALWAYS} {
+		dst := filepath.Join(tempDir, mode+".img")
+		err := copyFile(context.Background(), src, dst, actionOptions{reflink: mode})
+		if mode == ALWAYS && errors.Is(err, errors.ErrUnsupported) {
+			t.Logf("%s: the file system can't clone: %v", mode, err)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", mode, err)
+			continue
+		}
+		data, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("%s: expected the copy to match the source", mode)
+		}
+	}
+}
+
+// TestKernelCopies verifies the in-kernel copies carry the whole file where
+// the platform has them.
+func TestKernelCopies(t *testing.T) {
+	tempDir := t.TempDir()
+	content := bytes.Repeat([]byte("kernel copy "), 1<<14)
+	src := createTempFile(t, tempDir, "src.bin", string(content))
+
+	copies := map[string]func(ctx context.Context, out, in *os.File) error{
+		"copy_file_range": copyFileRange,
+		"sendfile":        sendFile,
+	}
+	for name, copy := range copies {
+		in, err := os.Open(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(tempDir, name+".bin")
+		out, err := os.Create(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = copy(context.Background(), out, in)
+		in.Close()
+		out.Close()
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		data, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("%s: expected the copy to match the source", name)
+		}
+	}
+}
+
+// TestGetReflinkMode verifies the modes are checked and default to auto.
+func TestGetReflinkMode(t *testing.T) {
+	if mode, err := getReflinkMode(""); err != nil || mode != AUTO {
+		t.Errorf("expected %q by default, got %q (%v)", AUTO, mode, err)
+	}
+	if _, err := getReflinkMode("sometimes"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// docInfo is the metadata read from a PDF or an office document.
+type docInfo struct {
+	title   string
+	author  string
+	created time.Time
+}
+
+// readDocument reads the title, author and creation time from the info
+// dictionary of PDF files, or the core properties of Office Open XML files
+// (DOCX, XLSX, PPTX). The format is sniffed from the content.
+func readDocument(fsys fileSystem, path string) (docInfo, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return docInfo{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return docInfo{}, err
+	}
+
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return docInfo{}, errNoMetadata
+	}
+	switch {
+	case bytes.Equal(head, []byte("%PDF-")):
+		return readPDF(f, stat.Size())
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return readOffice(f, stat.Size())
+	default:
+		return docInfo{}, errNoMetadata
+	}
+}
+
+// coreProperties is docProps/core.xml of an Office Open XML package.
+type coreProperties struct {
+	Title   string `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Created string `xml:"http://purl.org/dc/terms/ created"`
+}
+
+func readOffice(r io.ReaderAt, size int64) (docInfo, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return docInfo{}, errNoMetadata
+	}
+	f, err := zr.Open("docProps/core.xml")
+	if err != nil {
+		return docInfo{}, errNoMetadata
+	}
+	defer f.Close()
+
+	var props coreProperties
+	if err := xml.NewDecoder(io.LimitReader(f, maxExifSize)).Decode(&props); err != nil {
+		return docInfo{}, errNoMetadata
+	}
+	info := docInfo{
+		title:  strings.TrimSpace(props.Title),
+		author: strings.TrimSpace(props.Creator),
+	}
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(props.Created)); err == nil {
+		info.created = t
+	}
+	if info == (docInfo{}) {
+		return docInfo{}, errNoMetadata
+	}
+	return info, nil
+}
+
+// pdfTrailerSize bounds how much of the end of a PDF is searched for the
+// reference to the info dictionary.
+const pdfTrailerSize = 1 << 20
+
+var pdfInfoRef = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+
+// readPDF follows the /Info reference of the last trailer to the info
+// dictionary. Info dictionaries packed in compressed object streams aren't
+// read.
+func readPDF(r io.ReaderAt, size int64) (docInfo, error) {
+	tailSize := min(size, pdfTrailerSize)
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return docInfo{}, err
+	}
+	refs := pdfInfoRef.FindAllSubmatch(tail, -1)
+	if len(refs) == 0 {
+		return docInfo{}, errNoMetadata
+	}
+	ref := refs[len(refs)-1]
+
+	header := fmt.Sprintf("%s %s obj", ref[1], ref[2])
+	offset, err := findLast(r, size, []byte(header))
+	if err != nil {
+		return docInfo{}, err
+	}
+	object := make([]byte, min(size-offset, maxExifSize))
+	if _, err := r.ReadAt(object, offset); err != nil && err != io.EOF {
+		return docInfo{}, err
+	}
+
+	dict := pdfDict(object)
+	info := docInfo{
+		title:  strings.TrimSpace(dict["Title"]),
+		author: strings.TrimSpace(dict["Author"]),
+	}
+	if t, ok := parsePDFDate(dict["CreationDate"]); ok {
+		info.created = t
+	}
+	if info == (docInfo{}) {
+		return docInfo{}, errNoMetadata
+	}
+	return info, nil
+}
+
+// findLast returns the offset of the last occurrence of pattern that starts
+// a token, reading backwards from the end in chunks.
+func findLast(r io.ReaderAt, size int64, pattern []byte) (int64, error) {
+	const chunkSize = 1 << 20
+	buf := make([]byte, chunkSize+len(pattern))
+	for end := size; end > 0; end -= chunkSize {
+		start := max(0, end-chunkSize)
+		chunk := buf[:min(end+int64(len(pattern)), size)-start]
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(chunk); i > 0; {
+			i = bytes.LastIndex(chunk[:i], pattern)
+			if i < 0 {
+				break
+			}
+			prev := byte(' ')
+			switch {
+			case i > 0:
+				prev = chunk[i-1]
+			case start > 0:
+				// The byte before is in the next chunk to read.
+				var before [1]byte
+				if _, err := r.ReadAt(before[:], start-1); err != nil {
+					return 0, err
+				}
+				prev = before[0]
+			}
+			if !isDigit(prev) {
+				return start + int64(i), nil
+			}
+			i += len(pattern) - 1
+		}
+	}
+	return 0, errNoMetadata
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// pdfDict reads the string entries of the first dictionary in b, leaving out
+// the ones nested in other dictionaries or arrays.
+func pdfDict(b []byte) map[string]string {
+	entries := make(map[string]string)
+	start := bytes.Index(b, []byte("<<"))
+	if start < 0 {
+		return entries
+	}
+
+	depth := 0
+	key := ""
+	for i := start; i < len(b); {
+		c := b[i]
+		switch {
+		case bytes.HasPrefix(b[i:], []byte("<<")), c == '[':
+			depth++
+			key = ""
+			i++
+			if c == '<' {
+				i++
+			}
+		case bytes.HasPrefix(b[i:], []byte(">>")), c == ']':
+			depth--
+			key = ""
+			i++
+			if c == '>' {
+				i++
+			}
+			if depth == 0 {
+				return entries
+			}
+		case c == '/':
+			end := i + 1
+			for end < len(b) && !isPDFDelimiter(b[end]) {
+				end++
+			}
+			if depth == 1 && key == "" {
+				key = string(b[i+1 : end])
+			} else {
+				key = ""
+			}
+			i = end
+		case c == '(', c == '<':
+			var value []byte
+			if c == '(' {
+				value, i = pdfLiteral(b, i)
+			} else {
+				value, i = pdfHex(b, i)
+			}
+			if depth == 1 && key != "" {
+				entries[key] = pdfText(value)
+			}
+			key = ""
+		case isPDFSpace(c):
+			i++
+		default:
+			i++
+			for i < len(b) && !isPDFDelimiter(b[i]) {
+				i++
+			}
+			key = ""
+		}
+	}
+	return entries
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	default:
+		return false
+	}
+}
+
+func isPDFDelimiter(c byte) bool {
+	return isPDFSpace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// pdfLiteral decodes the (string) starting at b[i], returning it and the
+// index past its end.
+func pdfLiteral(b []byte, i int) ([]byte, int) {
+	var value []byte
+	depth := 0
+	for i < len(b) {
+		c := b[i]
+		i++
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return value, i
+			}
+		case '\\':
+			if i >= len(b) {
+				return value, i
+			}
+			c = b[i]
+			i++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// A backslash at the end of a line continues the string.
+				if c == '\r' && i < len(b) && b[i] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					n := int(c - '0')
+					for j := 0; j < 2 && i < len(b) && b[i] >= '0' && b[i] <= '7'; j++ {
+						n = n*8 + int(b[i]-'0')
+						i++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		value = append(value, c)
+	}
+	return value, i
+}
+
+// pdfHex decodes the <hex string> starting at b[i], returning it and the
+// index past its end.
+func pdfHex(b []byte, i int) ([]byte, int) {
+	end := bytes.IndexByte(b[i:], '>')
+	if end < 0 {
+		return nil, len(b)
+	}
+	digits := bytes.Map(func(r rune) rune {
+		if isPDFSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, b[i+1:i+end])
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	value := make([]byte, len(digits)/2)
+	for j := range value {
+		n, err := strconv.ParseUint(string(digits[2*j:2*j+2]), 16, 8)
+		if err != nil {
+			return nil, i + end + 1
+		}
+		value[j] = byte(n)
+	}
+	return value, i + end + 1
+}
+
+// pdfText decodes a text string, which is UTF-16 with a byte order mark,
+// UTF-8 with one, or else taken as Latin-1, which PDFDocEncoding mostly is.
+func pdfText(value []byte) string {
+	switch {
+	case bytes.HasPrefix(value, []byte{0xfe, 0xff}):
+		units := make([]uint16, (len(value)-2)/2)
+		for i := range units {
+			units[i] = uint16(value[2+2*i])<<8 | uint16(value[3+2*i])
+		}
+		return string(utf16.Decode(units))
+	case bytes.HasPrefix(value, []byte{0xef, 0xbb, 0xbf}):
+		return string(value[3:])
+	default:
+		runes := make([]rune, len(value))
+		for i, c := range value {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+}
+
+// parsePDFDate parses a date like D:20240601153012+02'00', where everything
+// after the year may be left out. Dates without an offset are taken as UTC.
+func parsePDFDate(s string) (time.Time, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+	if digits < 4 || digits > 14 || digits%2 == 1 {
+		return time.Time{}, false
+	}
+	stamp := s[:digits] + "0101000000"[digits-4:]
+	t, err := time.Parse("20060102150405", stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	zone := strings.ReplaceAll(s[digits:], "'", "")
+	if zone == "" || zone == "Z" || len(zone) < 3 {
+		return t, true
+	}
+	hours, err1 := strconv.Atoi(zone[1:3])
+	minutes, err2 := strconv.Atoi(cmp.Or(zone[3:], "0"))
+	if err1 != nil || err2 != nil {
+		return t, true
+	}
+	offset := hours*3600 + minutes*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	loc := time.FixedZone("", offset)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), true
+}
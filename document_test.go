@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// buildPDF lays out a PDF whose trailer points at info as object 7. Offsets
+// in the cross-reference table aren't needed by readPDF, so they are zero.
+func buildPDF(info string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	b.WriteString("1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	b.WriteString("17 0 obj\n<< /Title (Wrong object) >>\nendobj\n")
+	fmt.Fprintf(&b, "7 0 obj\n%s\nendobj\n", info)
+	b.WriteString("xref\n0 1\n0000000000 65535 f \n")
+	b.WriteString("trailer\n<< /Size 8 /Root 1 0 R /Info 7 0 R >>\nstartxref\n0\n%%EOF\n")
+	return b.Bytes()
+}
+
+func buildDOCX(t *testing.T, core string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range map[string]string{
+		"[Content_Types].xml": "<Types/>",
+		"docProps/core.xml":   core,
+	} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+const coreXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<dc:title>Quarterly Report</dc:title>
+<dc:creator>Ada Lovelace</dc:creator>
+<dcterms:created xsi:type="dcterms:W3CDTF">2024-03-04T05:06:07Z</dcterms:created>
+</cp:coreProperties>`
+
+// TestReadDocument verifies the title, author and creation time are read from each format.
+func TestReadDocument(t *testing.T) {
+	created := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	cases := map[string][]byte{
+		"scan.pdf": buildPDF(`<< /Title (Quarterly \(Q1\) Report) /Author <FEFF004100640061> ` +
+			`/Producer (Scanner) /Metadata << /Title (Nested) >> /CreationDate (D:20240304070607+02'00') >>`),
+		"report.docx": buildDOCX(t, coreXML),
+	}
+	expected := map[string]docInfo{
+		"scan.pdf":    {title: "Quarterly (Q1) Report", author: "Ada", created: created},
+		"report.docx": {title: "Quarterly Report", author: "Ada Lovelace", created: created},
+	}
+	tempDir := t.TempDir()
+	for name, content := range cases {
+		path := createTempFile(t, tempDir, name, string(content))
+		info, err := readDocument(osFS{}, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		want := expected[name]
+		if info.title != want.title || info.author != want.author || !info.created.Equal(want.created) {
+			t.Errorf("%s: expected %+v, got %+v", name, want, info)
+		}
+	}
+
+	path := createTempFile(t, tempDir, "empty.pdf", string(buildPDF("<< /Producer (Scanner) >>")))
+	if _, err := readDocument(osFS{}, path); err != errNoMetadata {
+		t.Errorf("empty.pdf: expected %v, got %v", errNoMetadata, err)
+	}
+}
+
+// TestFindLastAtChunkStart verifies a pattern starting a chunk is checked against the byte before it.
+func TestFindLastAtChunkStart(t *testing.T) {
+	const chunkSize = 1 << 20
+	pattern := []byte("7 0 obj")
+	for before, expected := range map[byte]int64{'\n': 100, '1': 10} {
+		data := bytes.Repeat([]byte(" "), chunkSize+100)
+		copy(data[10:], pattern)
+		copy(data[100:], pattern)
+		data[99] = before
+		offset, err := findLast(bytes.NewReader(data), int64(len(data)), pattern)
+		if err != nil || offset != expected {
+			t.Errorf("%q before: expected %d, got %d and %v", before, expected, offset, err)
+		}
+	}
+}
+
+// TestParsePDFDate verifies partial dates and time zone offsets are understood.
+func TestParsePDFDate(t *testing.T) {
+	cases := map[string]time.Time{
+		"D:2024":                  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		"D:20240304":              time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+		"D:20240304050607Z":       time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC),
+		"D:20240304050607-05'30'": time.Date(2024, 3, 4, 10, 36, 7, 0, time.UTC),
+		"20240304050607+01":       time.Date(2024, 3, 4, 4, 6, 7, 0, time.UTC),
+	}
+	for s, expected := range cases {
+		got, ok := parsePDFDate(s)
+		if !ok || !got.Equal(expected) {
+			t.Errorf("%q: expected %s, got %s (%t)", s, expected, got, ok)
+		}
+	}
+	for _, s := range []string{"", "D:", "D:202", "yesterday"} {
+		if _, ok := parsePDFDate(s); ok {
+			t.Errorf("%q: expected it to be rejected", s)
+		}
+	}
+}
+
+// TestWalkerWithDocumentTemplate verifies documents are named from their metadata,
+// and the ones without it are reported.
+func TestWalkerWithDocumentTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	scan := createTempFile(t, tempDir, "scan0001.pdf", string(buildPDF(
+		"<< /Title (Lease Agreement) /Author (ACME) /CreationDate (D:20230102) >>",
+	)))
+	bare := createTempFile(t, tempDir, "scan0002.pdf", string(buildPDF("<< >>")))
+
+	chain, err := parseOperations([]string{"template:{date} {author:unknown} - {title:untitled}{ext}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir}, chain: chain}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+
+	if got := filepath.Base(result.pairs[scan]); got != "2023-01-02 ACME - Lease Agreement.pdf" {
+		t.Errorf("expected the name to come from the metadata, got %q", got)
+	}
+	if _, ok := result.missing[scan]; ok {
+		t.Errorf("did not expect %s to be reported, got %v", scan, result.missing[scan])
+	}
+	if !slices.Equal(result.missing[bare], []string{"date", "author", "title"}) {
+		t.Errorf("expected date, author and title to be missing, got %v", result.missing[bare])
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MTIME string = "mtime"
+	BTIME string = "btime"
+)
+
+// filterOptions holds the attribute filters as given on the command line.
+type filterOptions struct {
+	minSize   string
+	maxSize   string
+	newerThan string
+	olderThan string
+	ageBy     string
+	owner     string
+	group     string
+	perm      string
+	kind      string
+}
+
+var errNoBirthTime = errors.New("not recorded by this file system")
+
+// fileFilter selects files by their attributes. The zero value accepts
+// every file.
+type fileFilter struct {
+	minSize, maxSize int64
+	// newerThan and olderThan are absolute times, computed from the ages
+	// when the filter is parsed.
+	newerThan, olderThan time.Time
+	ageBy                string
+	uid, gid             string
+	perm                 fs.FileMode
+	// permMatch is how perm is compared: '=' for exact bits, '-' for all of
+	// them and '/' for any of them.
+	permMatch byte
+	kinds     map[fs.FileMode]bool
+}
+
+var fileKinds = map[string]fs.FileMode{
+	"regular": 0,
+	"symlink": fs.ModeSymlink,
+	"fifo":    fs.ModeNamedPipe,
+	"socket":  fs.ModeSocket,
+	"device":  fs.ModeDevice,
+	"char":    fs.ModeDevice | fs.ModeCharDevice,
+}
+
+func parseFilter(opts filterOptions, now time.Time) (fileFilter, error) {
+	var f fileFilter
+	var err error
+	if f.minSize, err = parseSize(opts.minSize); err != nil {
+		return f, fmt.Errorf("min size: %w", err)
+	}
+	if f.maxSize, err = parseSize(opts.maxSize); err != nil {
+		return f, fmt.Errorf("max size: %w", err)
+	}
+
+	if opts.newerThan != "" {
+		age, err := parseAge(opts.newerThan)
+		if err != nil {
+			return f, fmt.Errorf("newer than: %w", err)
+		}
+		f.newerThan = now.Add(-age)
+	}
+	if opts.olderThan != "" {
+		age, err := parseAge(opts.olderThan)
+		if err != nil {
+			return f, fmt.Errorf("older than: %w", err)
+		}
+		f.olderThan = now.Add(-age)
+	}
+	switch strings.ToLower(opts.ageBy) {
+	case "", MTIME:
+		f.ageBy = MTIME
+	case BTIME:
+		f.ageBy = BTIME
+	default:
+		return f, fmt.Errorf("unknown age %q, expected mtime or btime", opts.ageBy)
+	}
+
+	if opts.owner != "" {
+		if f.uid, err = lookupOwner(opts.owner); err != nil {
+			return f, err
+		}
+	}
+	if opts.group != "" {
+		if f.gid, err = lookupGroup(opts.group); err != nil {
+			return f, err
+		}
+	}
+
+	if opts.perm != "" {
+		perm := opts.perm
+		f.permMatch = '='
+		if perm[0] == '-' || perm[0] == '/' {
+			f.permMatch = perm[0]
+			perm = perm[1:]
+		}
+		bits, err := strconv.ParseUint(perm, 8, 32)
+		if err != nil || bits > 0o777 {
+			return f, fmt.Errorf("invalid permission %q", opts.perm)
+		}
+		f.perm = fs.FileMode(bits)
+	}
+
+	if opts.kind != "" {
+		f.kinds = make(map[fs.FileMode]bool)
+		for _, kind := range strings.Split(opts.kind, ",") {
+			mode, ok := fileKinds[strings.ToLower(strings.TrimSpace(kind))]
+			if !ok {
+				return f, fmt.Errorf("unknown file kind %q", kind)
+			}
+			f.kinds[mode] = true
+		}
+	}
+	return f, nil
+}
+
+// match reports whether the file on fsys passes the filter. The file info is
+// only looked up when an attribute filter needs it.
+func (f fileFilter) match(fsys fileSystem, path string, file fs.DirEntry) (bool, error) {
+	if f.kinds != nil && !f.kinds[file.Type()] {
+		return false, nil
+	}
+	if !f.needsInfo() {
+		return true, nil
+	}
+
+	info, err := file.Info()
+	if err != nil {
+		return false, fmt.Errorf("get file(%q) info: %w", path, err)
+	}
+	if info.Size() < f.minSize || (f.maxSize > 0 && info.Size() > f.maxSize) {
+		return false, nil
+	}
+
+	if !f.newerThan.IsZero() || !f.olderThan.IsZero() {
+		t := info.ModTime()
+		if f.ageBy == BTIME {
+			bt, ok := fsys.(birthTimer)
+			if !ok {
+				return false, fmt.Errorf("get file(%q) creation time: %w", path, errNoBirthTime)
+			}
+			if t, err = bt.BirthTime(path, info); err != nil {
+				return false, fmt.Errorf("get file(%q) creation time: %w", path, err)
+			}
+		}
+		if !f.newerThan.IsZero() && t.Before(f.newerThan) {
+			return false, nil
+		}
+		if !f.olderThan.IsZero() && t.After(f.olderThan) {
+			return false, nil
+		}
+	}
+
+	if f.uid != "" || f.gid != "" {
+		uid, gid, ok := fileOwner(info)
+		if !ok {
+			return false, fmt.Errorf("get file(%q) owner: not supported", path)
+		}
+		if (f.uid != "" && uid != f.uid) || (f.gid != "" && gid != f.gid) {
+			return false, nil
+		}
+	}
+
+	if f.permMatch != 0 {
+		perm := info.Mode().Perm()
+		switch f.permMatch {
+		case '-':
+			return perm&f.perm == f.perm, nil
+		case '/':
+			return perm&f.perm != 0, nil
+		default:
+			return perm == f.perm, nil
+		}
+	}
+	return true, nil
+}
+
+func (f fileFilter) needsInfo() bool {
+	return f.minSize > 0 || f.maxSize > 0 ||
+		!f.newerThan.IsZero() || !f.olderThan.IsZero() ||
+		f.uid != "" || f.gid != "" || f.permMatch != 0
+}
+
+// parseSize reads sizes such as "512", "10k", "1.5M" or "2GiB", in powers of
+// 1024. An empty size is zero.
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	unit := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		unit = 1 << (10 * (strings.IndexByte("KMGT", s[i]) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// parseAge reads ages such as "7d", "2w" or anything time.ParseDuration
+// accepts.
+func parseAge(age string) (time.Duration, error) {
+	day := 24 * time.Hour
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, ok := strings.CutSuffix(age, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid age %q", age)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+	return d, nil
+}
+
+// lookupOwner resolves a user name, or takes a numeric uid as it is.
+func lookupOwner(owner string) (string, error) {
+	if _, err := strconv.ParseUint(owner, 10, 32); err == nil {
+		return owner, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return "", fmt.Errorf("lookup owner: %w", err)
+	}
+	return u.Uid, nil
+}
+
+// lookupGroup resolves a group name, or takes a numeric gid as it is.
+func lookupGroup(group string) (string, error) {
+	if _, err := strconv.ParseUint(group, 10, 32); err == nil {
+		return group, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return "", fmt.Errorf("lookup group: %w", err)
+	}
+	return g.Gid, nil
+}
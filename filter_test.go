@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseSize verifies sizes are read in powers of 1024.
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"":     0,
+		"512":  512,
+		"10k":  10 << 10,
+		"1.5M": 3 << 19,
+		"2GiB": 2 << 30,
+		"1TB":  1 << 40,
+	}
+	for size, expected := range cases {
+		got, err := parseSize(size)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", size, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%q: expected %d, got %d", size, expected, got)
+		}
+	}
+	for _, size := range []string{"B", "ten", "-5", "5X"} {
+		if _, err := parseSize(size); err == nil {
+			t.Errorf("%q: expected an error", size)
+		}
+	}
+}
+
+// TestParseAge verifies ages accept days, weeks and Go durations.
+func TestParseAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"7d":  7 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"36h": 36 * time.Hour,
+		"90m": 90 * time.Minute,
+	}
+	for age, expected := range cases {
+		got, err := parseAge(age)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", age, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%q: expected %s, got %s", age, expected, got)
+		}
+	}
+	for _, age := range []string{"", "d", "week", "-3d"} {
+		if _, err := parseAge(age); err == nil {
+			t.Errorf("%q: expected an error", age)
+		}
+	}
+}
+
+// TestWalkerWithAttributeFilters verifies walker selects files by size, age and permissions.
+func TestWalkerWithAttributeFilters(t *testing.T) {
+	tempDir := t.TempDir()
+	large := createTempFile(t, tempDir, "large_x.mkv", strings.Repeat("x", 2048))
+	small := createTempFile(t, tempDir, "small_x.mkv", "x")
+	recent := createTempFile(t, tempDir, "recent_x.mkv", strings.Repeat("x", 2048))
+
+	past := time.Now().Add(-60 * 24 * time.Hour)
+	for _, path := range []string{large, small} {
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(large, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := parseFilter(filterOptions{minSize: "1k", olderThan: "30d"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir, str: "_x"}, filter: filter}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+	if _, ok := pairs[large]; !ok {
+		t.Errorf("expected file %s to be in pairs", large)
+	}
+	for _, path := range []string{small, recent} {
+		if _, ok := pairs[path]; ok {
+			t.Errorf("did not expect file %s in pairs", path)
+		}
+	}
+
+	cfg.filter, err = parseFilter(filterOptions{perm: "/044"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs = result.pairs
+	if _, ok := pairs[large]; ok {
+		t.Errorf("did not expect private file %s in pairs", large)
+	}
+	if len(pairs) != 2 {
+		t.Errorf("expected 2 files to be processed, got %v", pairs)
+	}
+}
+
+// TestBirthTimeOffTheLocalDisk verifies creation times aren't looked up on the local disk for other file systems.
+func TestBirthTimeOffTheLocalDisk(t *testing.T) {
+	tempDir := t.TempDir()
+	path := createTempFile(t, tempDir, "a_x.txt", "local")
+	m := newMemFS()
+	if err := m.WriteFile(path, []byte("memory"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := parseFilter(filterOptions{olderThan: "1d", ageBy: BTIME}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir, str: "_x"}, filter: filter, fsys: m}
+	if _, err := walker(context.Background(), cfg, nil); !errors.Is(err, errNoBirthTime) {
+		t.Errorf("expected %v, got %v", errNoBirthTime, err)
+	}
+}
+
+// TestWalkerWithKindFilter verifies walker selects files by kind.
+func TestWalkerWithKindFilter(t *testing.T) {
+	tempDir := t.TempDir()
+	regular := createTempFile(t, tempDir, "file_x.txt", "dummy")
+	link := filepath.Join(tempDir, "link_x.txt")
+	if err := os.Symlink(regular, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	filter, err := parseFilter(filterOptions{kind: "symlink"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir, str: "_x"}, filter: filter}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+	if _, ok := pairs[link]; !ok {
+		t.Errorf("expected symlink %s to be in pairs", link)
+	}
+	if _, ok := pairs[regular]; ok {
+		t.Errorf("did not expect file %s in pairs", regular)
+	}
+}
+
+// TestParseFilterInvalid verifies malformed filters are rejected.
+func TestParseFilterInvalid(t *testing.T) {
+	cases := []filterOptions{
+		{minSize: "big"},
+		{newerThan: "soon"},
+		{ageBy: "atime"},
+		{perm: "999"},
+		{perm: "-rwx"},
+		{kind: "folder"},
+		{owner: "no-such-user-for-omitter"},
+	}
+	for _, opts := range cases {
+		if _, err := parseFilter(opts, time.Now()); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileSystem is what files are planned and applied on. osFS is the local
+// disk, and memFS keeps everything in memory, for tests.
+type fileSystem interface {
+	// WalkDir walks the tree at root the way filepath.WalkDir does.
+	WalkDir(root string, fn fs.WalkDirFunc) error
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Open(name string) (readFile, error)
+	// Create creates a file for writing, failing if name exists.
+	Create(name string, perm fs.FileMode) (writeFile, error)
+	// Rename renames oldname to newname. Unless overwrite is set, it fails
+	// if newname exists instead of replacing it.
+	Rename(oldname, newname string, overwrite bool) error
+	Link(oldname, newname string) error
+	Remove(name string) error
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// readFile is a file opened for reading, which the metadata readers seek
+// around in.
+type readFile interface {
+	fs.File
+	io.ReaderAt
+	io.Seeker
+}
+
+// writeFile is a file opened for writing.
+type writeFile interface {
+	io.Writer
+	Name() string
+	Chmod(mode fs.FileMode) error
+	Sync() error
+	Close() error
+}
+
+// dirSyncer is implemented by file systems that can make a rename in dir
+// durable.
+type dirSyncer interface {
+	SyncDir(dir string) error
+}
+
+// copier is implemented by file systems that copy files themselves, like
+// object stores copying on the server. Unless overwrite is set, Copy fails
+// if dst exists.
+type copier interface {
+	Copy(src, dst string, overwrite bool) error
+}
+
+// mover is implemented by file systems that move files by renaming them,
+// like remote hosts, rather than by copying and removing them. If Move fails
+// for other reasons than dst existing, as across devices, the file is copied
+// after all.
+type mover interface {
+	Move(src, dst string, overwrite bool) error
+}
+
+// birthTimer is implemented by file systems that can tell when a file was
+// created. The others have no creation times to filter by.
+type birthTimer interface {
+	BirthTime(name string, info fs.FileInfo) (time.Time, error)
+}
+
+// orOS returns fsys, or the local disk if it is nil.
+func orOS(fsys fileSystem) fileSystem {
+	if fsys == nil {
+		return osFS{}
+	}
+	return fsys
+}
+
+// osFS is the local disk.
+type osFS struct{}
+
+func (osFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFS) Open(name string) (readFile, error) {
+	return os.Open(name)
+}
+
+func (osFS) Create(name string, perm fs.FileMode) (writeFile, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+}
+
+func (osFS) Rename(oldname, newname string, overwrite bool) error {
+	return renameFile(oldname, newname, overwrite)
+}
+
+func (osFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) BirthTime(name string, info fs.FileInfo) (time.Time, error) {
+	return birthTime(name, info)
+}
+
+// SyncDir syncs the directory. Not every platform can sync a directory, so
+// failing to is not an error.
+func (osFS) SyncDir(dir string) error {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
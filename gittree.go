@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// gitTree is the working tree of a repository files are renamed in. Only
+// the files it tracks are renamed, and each rename is staged the way git mv
+// stages it: the index entry moves to the new name, so history follows the
+// file and changes not yet staged stay unstaged.
+type gitTree struct {
+	repo *git.Repository
+	root string
+	// tracked holds the paths of the index, relative to root and slash
+	// separated.
+	tracked map[string]bool
+	// untracked also takes the files git doesn't track, unless they are
+	// ignored. They are renamed, but not staged.
+	untracked bool
+	ignored   gitignore.Matcher
+}
+
+// openGitTree opens the working tree path is in.
+func openGitTree(path string, untracked bool) (*gitTree, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpenWithOptions(abs, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, fmt.Errorf("%s isn't in a git working tree", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	patterns, err := gitignore.ReadPatterns(wt.Filesystem, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read ignore files: %w", err)
+	}
+
+	g := &gitTree{
+		repo:      repo,
+		root:      wt.Filesystem.Root(),
+		tracked:   make(map[string]bool, len(idx.Entries)),
+		untracked: untracked,
+		ignored:   gitignore.NewMatcher(append(patterns, wt.Excludes...)),
+	}
+	for _, e := range idx.Entries {
+		g.tracked[e.Name] = true
+	}
+	return g, nil
+}
+
+// rel returns path relative to the root of the tree, slash separated, or
+// false if it is outside of it.
+func (g *gitTree) rel(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(g.root, abs)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// includes reports whether the file at path is one to rename.
+func (g *gitTree) includes(path string) bool {
+	name, ok := g.rel(path)
+	if !ok {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	switch {
+	case g.tracked[name]:
+		return true
+	case slices.Contains(parts, ".git"):
+		return false
+	default:
+		return g.untracked && !g.ignored.Match(parts, false)
+	}
+}
+
+// stage moves the index entries of the tracked sources that are done to
+// their destinations, and returns how many it moved. An entry already at a
+// destination is replaced, and the entry of a file moved out of the tree is
+// removed.
+func (g *gitTree) stage(pairs map[string]string, done map[string]bool) (int, error) {
+	// Every entry is looked up by its name before any is renamed, so files
+	// swapping names don't pick up each other's entries.
+	moved := make(map[string]string)
+	targets := make(map[string]string)
+	for src, dst := range pairs {
+		from, ok := g.rel(src)
+		if !done[src] || !ok || !g.tracked[from] {
+			continue
+		}
+		to, ok := g.rel(dst)
+		if ok {
+			targets[to] = dst
+		}
+		moved[from] = to
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return 0, fmt.Errorf("read index: %w", err)
+	}
+	entries := make([]*index.Entry, 0, len(idx.Entries))
+	var n int
+	for _, e := range idx.Entries {
+		to, ok := moved[e.Name]
+		switch {
+		case ok && to != "":
+			e.Name = to
+			if info, err := os.Lstat(targets[to]); err == nil {
+				e.ModifiedAt = info.ModTime()
+			}
+			entries = append(entries, e)
+			n++
+		case ok:
+			n++
+		case targets[e.Name] != "":
+			// Replaced by the file moved onto it.
+		default:
+			entries = append(entries, e)
+		}
+	}
+	idx.Entries = entries
+	if err := g.repo.Storer.SetIndex(idx); err != nil {
+		return 0, fmt.Errorf("write index: %w", err)
+	}
+	for from := range moved {
+		delete(g.tracked, from)
+	}
+	for to := range targets {
+		g.tracked[to] = true
+	}
+	return n, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// initRepo creates a repository holding the files, and adds the tracked
+// ones to its index.
+func initRepo(t *testing.T, files map[string]string, tracked ...string) (string, *git.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		createTempFile(t, filepath.Dir(path), filepath.Base(path), content)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range tracked {
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, repo
+}
+
+// indexEntries maps the names in the index to their hashes.
+func indexEntries(t *testing.T, repo *git.Repository) map[string]plumbing.Hash {
+	t.Helper()
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]plumbing.Hash)
+	for _, e := range idx.Entries {
+		entries[e.Name] = e.Hash
+	}
+	return entries
+}
+
+// TestGitRenamesTrackedFiles verifies only tracked files are renamed, and the renames are staged.
+func TestGitRenamesTrackedFiles(t *testing.T) {
+	files := map[string]string{
+		".gitignore":    "*.log\n",
+		"aaa_1.txt":     "one",
+		"sub/aaa_2.txt": "two",
+		"aaa_3.txt":     "untracked",
+		"aaa_4.log":     "ignored",
+	}
+	dir, repo := initRepo(t, files, ".gitignore", "aaa_1.txt", "sub/aaa_2.txt")
+	before := indexEntries(t, repo)
+
+	tree, err := openGitTree(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: dir, str: "aaa_"}, git: tree}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	expected := []string{filepath.Join(dir, "aaa_1.txt"), filepath.Join(dir, "sub", "aaa_2.txt")}
+	if got := slices.Sorted(maps.Keys(result.pairs)); !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	opts := actionOptions{order: result.order, done: make(map[string]bool)}
+	if _, err := renameAction(context.Background(), result.pairs, opts); err != nil {
+		t.Fatal(err)
+	}
+	n, err := tree.stage(result.pairs, opts.done)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 files staged, got %d and %v", n, err)
+	}
+
+	after := indexEntries(t, repo)
+	if len(after) != 3 || after["1.txt"] != before["aaa_1.txt"] || after["sub/2.txt"] != before["sub/aaa_2.txt"] {
+		t.Errorf("expected the entries to be moved with their hashes, got %v", after)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.txt", "sub/2.txt"} {
+		if s := status.File(name); s.Worktree != git.Unmodified {
+			t.Errorf("%s: expected no unstaged change, got %q", name, s.Worktree)
+		}
+	}
+}
+
+// TestGitUntrackedFiles verifies untracked files are taken when asked, but not ignored ones.
+func TestGitUntrackedFiles(t *testing.T) {
+	files := map[string]string{
+		".gitignore":      "*.log\nbuild/\n",
+		"aaa_1.txt":       "tracked",
+		"aaa_2.txt":       "untracked",
+		"aaa_3.log":       "ignored",
+		"build/aaa_4.txt": "ignored",
+	}
+	dir, _ := initRepo(t, files, ".gitignore", "aaa_1.txt")
+	tree, err := openGitTree(filepath.Join(dir, "."), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]bool{
+		"aaa_1.txt": true, "aaa_2.txt": true, "aaa_3.log": false, "build/aaa_4.txt": false,
+		".git/config": false,
+	} {
+		if got := tree.includes(filepath.Join(dir, filepath.FromSlash(name))); got != expected {
+			t.Errorf("%s: expected %t, got %t", name, expected, got)
+		}
+	}
+	if tree.includes(filepath.Join(t.TempDir(), "aaa_5.txt")) {
+		t.Error("expected files outside the working tree to be left out")
+	}
+}
+
+// TestGitStageSwap verifies files swapping names keep their own entries.
+func TestGitStageSwap(t *testing.T) {
+	dir, repo := initRepo(t, map[string]string{"a": "first", "b": "second"}, "a", "b")
+	before := indexEntries(t, repo)
+	tree, err := openGitTree(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	pairs := map[string]string{a: b, b: a}
+	if n, err := tree.stage(pairs, map[string]bool{a: true, b: true}); err != nil || n != 2 {
+		t.Fatalf("expected 2 files staged, got %d and %v", n, err)
+	}
+	after := indexEntries(t, repo)
+	if after["a"] != before["b"] || after["b"] != before["a"] {
+		t.Errorf("expected the hashes to be swapped, got %v", after)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+const (
+	SHA256 string = "sha256"
+	BLAKE3 string = "blake3"
+	XXHASH string = "xxhash"
+)
+
+// hashPreference orders the algorithms by strength, for picking the one to
+// compare files with.
+var hashPreference = []string{BLAKE3, SHA256, XXHASH}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(), nil
+	case XXHASH:
+		return xxhash.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash %q", algorithm)
+	}
+}
+
+// hashFile computes every digest in a single read of the file, as hex. It
+// stops part way once ctx is done.
+func hashFile(ctx context.Context, fsys fileSystem, path string, algorithms []string) (map[string]string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		if hashes[i], err = newHash(algorithm); err != nil {
+			return nil, err
+		}
+		writers[i] = hashes[i]
+	}
+	if _, err = io.Copy(io.MultiWriter(writers...), contextReader{ctx, f}); err != nil {
+		return nil, fmt.Errorf("read file(%q): %w", path, err)
+	}
+
+	digests := make(map[string]string, len(algorithms))
+	for i, algorithm := range algorithms {
+		digests[algorithm] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return digests, nil
+}
+
+// strongestHash returns the strongest of the computed digests.
+func strongestHash(digests map[string]string) (string, bool) {
+	for _, algorithm := range hashPreference {
+		if _, ok := digests[algorithm]; ok {
+			return algorithm, true
+		}
+	}
+	return "", false
+}
+
+// addHash adds the algorithm to the list, once.
+func addHash(algorithms []string, algorithm string) []string {
+	if slices.Contains(algorithms, algorithm) {
+		return algorithms
+	}
+	return append(algorithms, algorithm)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestHashFile verifies every digest is computed from a single read.
+func TestHashFile(t *testing.T) {
+	path := createTempFile(t, t.TempDir(), "abc.txt", "abc")
+
+	digests, err := hashFile(context.Background(), osFS{}, path, []string{SHA256, BLAKE3, XXHASH})
+	if err != nil {
+		t.Fatalf("hash error: %v", err)
+	}
+	expected := map[string]string{
+		SHA256: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		BLAKE3: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+		XXHASH: "44bc2cf5ad770999",
+	}
+	for algorithm, digest := range expected {
+		if digests[algorithm] != digest {
+			t.Errorf("%s: expected %s, got %s", algorithm, digest, digests[algorithm])
+		}
+	}
+
+	if _, err := hashFile(context.Background(), osFS{}, path, []string{"md4"}); err == nil {
+		t.Error("expected an error for an unknown hash")
+	}
+}
+
+// TestWalkerWithHashTemplate verifies files are named from a truncated digest.
+func TestWalkerWithHashTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	file1 := createTempFile(t, tempDir, "asset.txt", "abc")
+
+	chain, err := parseOperations([]string{"template:{stem}-{sha256:8}{ext}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir}, chain: chain}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+
+	expected := "asset-ba7816bf.txt"
+	if got := filepath.Base(pairs[file1]); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// TestWalkerHashedDuplicates verifies byte-identical files only skip suffixes with -dedupe.
+func TestWalkerHashedDuplicates(t *testing.T) {
+	tempDir := t.TempDir()
+	createTempFile(t, tempDir, "a.txt", "same")
+	createTempFile(t, tempDir, "b.txt", "same")
+	createTempFile(t, tempDir, "c.txt", "different")
+
+	chain, err := parseOperations([]string{"template:{xxhash}{ext}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dedupe, expected := range map[string]int{"": 3, SKIP: 2} {
+		cfg := config{options: fileOptions{path: tempDir, dedupe: dedupe}, chain: chain}
+		result, err := walker(context.Background(), cfg, nil)
+		if err != nil {
+			t.Fatalf("walker error: %v", err)
+		}
+		pairs := result.pairs
+		if len(pairs) != expected {
+			t.Fatalf("dedupe %q: expected %d files to be processed, got %v", dedupe, expected, pairs)
+		}
+		var suffixed int
+		for _, newPath := range pairs {
+			if name := filepath.Base(newPath); len(name) != len("0123456789abcdef.txt") {
+				suffixed++
+			}
+		}
+		if suffixed != expected-2 {
+			t.Errorf("dedupe %q: expected %d suffixed name(s), got %v", dedupe, expected-2, pairs)
+		}
+	}
+}
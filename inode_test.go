@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWalkerKeepsHardLinks verifies hard-linked files are linked again in the output.
+func TestWalkerKeepsHardLinks(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	first := createTempFile(t, srcDir, "a_target.txt", "shared")
+	second := filepath.Join(srcDir, "b_target.txt")
+	if err := os.Link(first, second); err != nil {
+		t.Fatal(err)
+	}
+	createTempFile(t, srcDir, "c_target.txt", "shared")
+
+	cfg := config{options: fileOptions{path: srcDir, str: "_target", output: dstDir}}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if len(result.links) != 1 || result.links[filepath.Join(dstDir, "b.txt")] != filepath.Join(dstDir, "a.txt") {
+		t.Fatalf("expected b.txt to link to a.txt, got %v", result.links)
+	}
+
+	opts := actionOptions{links: result.links, order: result.order}
+	if _, err := copyAction(context.Background(), result.pairs, opts); err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+	infos := make(map[string]os.FileInfo)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if infos[name], err = os.Stat(filepath.Join(dstDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !os.SameFile(infos["a.txt"], infos["b.txt"]) {
+		t.Error("expected a.txt and b.txt to be hard links")
+	}
+	if os.SameFile(infos["a.txt"], infos["c.txt"]) {
+		t.Error("did not expect c.txt to be linked")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// inode identifies a file across its hard links.
+type inode struct {
+	dev, ino uint64
+}
+
+// hardLinked returns the inode of the file if it has more than one link.
+func hardLinked(info fs.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"bufio"
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pooulad/ravan"
@@ -28,25 +34,196 @@ type fileOptions struct {
 	replace          string
 	output           string
 	transmissionType string
+	sanitize         string
+	operations       operationsFlag
+	onConflict       string
+	suffixFormat     string
+	filters          filterOptions
+	dedupe           string
+	keepTree         bool
+	sortBy           string
+	verify           bool
+	verifyRetries    int
+	reflink          string
+	s3Endpoint       string
+	git              bool
+	gitUntracked     bool
+	rewrite          string
 }
 type config struct {
 	options         fileOptions
+	paths           []string
+	rules           string
+	chain           chain
+	filter          fileFilter
 	withVerbose     bool
 	withDryRun      bool
 	withInteractive bool
 	withRegex       bool
+	resume          bool
 	help            bool
+
+	// fsys is what the files are on, the local disk when nil.
+	fsys fileSystem
+	// git, if set, is the working tree whose tracked files are renamed.
+	git *gitTree
+	// resolver, if set, is shared by the walks of several paths. Otherwise
+	// each walk plans on its own.
+	resolver *conflictResolver
+}
+
+// plan is what walker found to do.
+type plan struct {
+	// pairs maps each source to its destination.
+	pairs map[string]string
+	// links maps the destinations that duplicate another file to the path
+	// they are hard-linked to, instead of being written.
+	links map[string]string
+	// duplicates maps the sources left alone as duplicates to the path they
+	// duplicate.
+	duplicates map[string]string
+	// missing maps the sources named by a template to the metadata
+	// variables they had no value for.
+	missing map[string][]string
+	// order lists the sources of pairs in the order they are applied.
+	order []string
+}
+
+func newPlan() plan {
+	return plan{
+		pairs:      make(map[string]string),
+		links:      make(map[string]string),
+		duplicates: make(map[string]string),
+		missing:    make(map[string][]string),
+	}
+}
+
+// actionOptions tunes how the actions write their destinations.
+type actionOptions struct {
+	// overwrite allows replacing an existing destination. Otherwise every
+	// destination is created exclusively.
+	overwrite bool
+	// links maps destinations to the path they are hard-linked to.
+	links map[string]string
+	// order lists the sources in the order they are applied. Those left
+	// out come last, by path.
+	order []string
+	// verify checks each copy against its source by checksum, copying
+	// again up to retries times on a mismatch. Moves only remove the
+	// source once its copy checks out.
+	verify  bool
+	retries int
+	// reflink is whether copies are cloned on copy-on-write file systems:
+	// auto, always or never.
+	reflink string
+	// done, if set, collects the sources the action is done with.
+	done map[string]bool
+	// fsys is what the files are on, the local disk when nil.
+	fsys fileSystem
+	// git, if set, is the working tree the renames and moves are staged in.
+	git *gitTree
+	// roots are the folders or URLs the run is over, which name its saved
+	// state.
+	roots []string
+	// progress, if set, logs the sources the action is done with.
+	progress *progressLog
+	// rewrites are the text files whose references are updated once the
+	// files are renamed.
+	rewrites []pendingRewrite
 }
 
 func main() {
-	cfg := parseFlags()
-	if cfg.options.path == "" || cfg.options.str == "" || cfg.help {
+	cfg, err := parseFlags()
+	if err != nil {
+		fmt.Println("load rules:", err)
+		os.Exit(1)
+	}
+	paths := cfg.paths
+	if cfg.options.path != "" {
+		paths = []string{cfg.options.path}
+	}
+	roots := stateRoots(paths)
+	if cfg.resume {
+		resume(roots, cfg.withVerbose)
+	}
+	if len(paths) == 0 || cfg.help ||
+		(cfg.options.str == "" && cfg.options.sanitize == "" &&
+			len(cfg.options.operations) == 0) {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	profile, err := getSanitizeProfile(cfg.options.sanitize)
+	if err != nil {
+		fmt.Println("sanitize:", err)
+		os.Exit(1)
+	}
+	cfg.options.sanitize = profile
+
+	cfg.options.onConflict, err = getConflictPolicy(cfg.options.onConflict)
+	if err != nil {
+		fmt.Println("on conflict:", err)
+		os.Exit(1)
+	}
+	if err = validateSuffixFormat(cfg.options.suffixFormat); err != nil {
+		fmt.Println("on conflict:", err)
+		os.Exit(1)
+	}
+	cfg.options.dedupe, err = getDedupeMode(cfg.options.dedupe)
+	if err != nil {
+		fmt.Println("dedupe:", err)
+		os.Exit(1)
+	}
+	if cfg.options.dedupe == LINK && cfg.options.output == "" {
+		fmt.Println("dedupe: link needs an output")
+		os.Exit(1)
+	}
+	cfg.options.sortBy, err = getSortKey(cfg.options.sortBy)
+	if err != nil {
+		fmt.Println("sort:", err)
+		os.Exit(1)
+	}
+	cfg.options.reflink, err = getReflinkMode(cfg.options.reflink)
+	if err != nil {
+		fmt.Println("reflink:", err)
+		os.Exit(1)
+	}
+	if cfg.options.verify && cfg.options.output == "" {
+		fmt.Println("verify: needs an output")
+		os.Exit(1)
+	}
+	if cfg.options.verifyRetries < 0 {
+		fmt.Println("verify: retries can't be negative")
+		os.Exit(1)
+	}
+	if cfg.options.keepTree && cfg.options.output == "" {
+		fmt.Println("keep tree: needs an output")
+		os.Exit(1)
+	}
+	if cfg.options.gitUntracked && !cfg.options.git {
+		fmt.Println("git untracked: needs git")
+		os.Exit(1)
+	}
+
+	cfg.filter, err = parseFilter(cfg.options.filters, time.Now())
+	if err != nil {
+		fmt.Println("filter:", err)
+		os.Exit(1)
+	}
+
+	cfg.chain, err = parseOperations(cfg.options.operations)
+	if err != nil {
+		fmt.Println("parse operations:", err)
+		os.Exit(1)
+	}
+
+	rewritePatterns, err := parseRewritePatterns(cfg.options.rewrite)
+	if err != nil {
+		fmt.Println("rewrite:", err)
+		os.Exit(1)
+	}
+
 	var pattern *regexp.Regexp
-	var err error
 	if cfg.withRegex {
 		pattern, err = regexp.Compile(cfg.options.str)
 		if err != nil {
@@ -54,23 +231,173 @@ func main() {
 			os.Exit(1)
 		}
 	}
-	pairs, err := walker(cfg, pattern)
-	if err != nil {
-		fmt.Println("walk dir:", err)
-		os.Exit(2)
+	// Paths in a bucket are planned and applied on its objects, and copied
+	// on the server.
+	if slices.ContainsFunc(paths, isS3Path) || isS3Path(cfg.options.output) {
+		paths, cfg.options.output, err = s3Paths(paths, cfg.options.output)
+		if err != nil {
+			fmt.Println("s3:", err)
+			os.Exit(1)
+		}
+		if cfg.options.dedupe == LINK {
+			fmt.Println("s3: dedupe link needs hard links, which buckets don't have")
+			os.Exit(1)
+		}
+		cfg.fsys, err = newS3FS(cfg.options.s3Endpoint)
+		if err != nil {
+			fmt.Println("s3:", err)
+			os.Exit(1)
+		}
 	}
+	// Paths on a remote host are planned and applied over SFTP.
+	if slices.ContainsFunc(paths, isSFTPPath) || isSFTPPath(cfg.options.output) {
+		var host string
+		paths, cfg.options.output, host, err = sftpPaths(paths, cfg.options.output)
+		if err != nil {
+			fmt.Println("sftp:", err)
+			os.Exit(1)
+		}
+		cfg.fsys, err = dialSFTP(host)
+		if err != nil {
+			fmt.Println("sftp:", err)
+			os.Exit(2)
+		}
+	}
+	if !cfg.withDryRun {
+		sweep(orOS(cfg.fsys), append(slices.Clone(paths), cfg.options.output), cfg.withVerbose)
+	}
+	// The entries of an archive are renamed inside it, and -output is where
+	// the new archive goes.
+	var arc *archive
+	archiveOutput := cfg.options.output
+	if cfg.fsys == nil && slices.ContainsFunc(paths, func(p string) bool { return archiveFormat(p) != "" }) {
+		if len(paths) > 1 {
+			fmt.Println("archive:", errMixedArchive)
+			os.Exit(1)
+		}
+		if cfg.options.dedupe == LINK || cfg.options.keepTree || len(rewritePatterns) > 0 {
+			fmt.Println("archive: dedupe link, keep tree and rewrite are for folders")
+			os.Exit(1)
+		}
+		arc, err = openArchive(paths[0])
+		if err != nil {
+			fmt.Println("archive:", err)
+			os.Exit(2)
+		}
+		paths = []string{arc.path}
+		cfg.fsys = arc.fsys
+		cfg.options.output = ""
+		cfg.options.verify = false
+	}
+	if _, ok := orOS(cfg.fsys).(birthTimer); cfg.filter.ageBy == BTIME && !ok {
+		fmt.Println("filter: age by btime:", errNoBirthTime)
+		os.Exit(1)
+	}
+	// In a working tree, only the files git knows are renamed, and the
+	// renames are staged.
+	if cfg.options.git {
+		if cfg.fsys != nil {
+			fmt.Println("git: only works on local folders")
+			os.Exit(1)
+		}
+		cfg.git, err = openGitTree(paths[0], cfg.options.gitUntracked)
+		if err != nil {
+			fmt.Println("git:", err)
+			os.Exit(1)
+		}
+		for _, path := range paths[1:] {
+			if _, ok := cfg.git.rel(path); !ok {
+				fmt.Printf("git: %s isn't in the working tree of %s\n", path, paths[0])
+				os.Exit(1)
+			}
+		}
+	}
+
+	ctx, stop := interruptible()
+	// One resolver plans the files of every path, so two files from
+	// different paths never end up with the same destination.
+	cfg.resolver = newConflictResolver(
+		orOS(cfg.fsys), cfg.options.onConflict, cfg.options.suffixFormat, cfg.options.dedupe,
+	)
+	var result plan
+	for _, path := range paths {
+		cfg.options.path = path
+		found, err := walker(ctx, cfg, pattern)
+		if arc != nil {
+			// The content of the entries is only read while planning.
+			arc.close()
+		}
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("\nInterrupted.")
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Println("walk dir:", err)
+			os.Exit(2)
+		}
+		result = found
+	}
+	pairs := result.pairs
 
 	actionName := getActionName(cfg.options.output, cfg.options.transmissionType)
+	// References are found while the whole plan is known, and updated once
+	// the files are renamed, by -resume if the run stops before.
+	var rewrites []rewrite
+	if len(rewritePatterns) > 0 {
+		if actionName == COPY {
+			fmt.Println("rewrite: copies leave the files under their old names")
+			os.Exit(1)
+		}
+		rewrites, err = planRewrites(ctx, orOS(cfg.fsys), paths, rewritePatterns, pairs)
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("\nInterrupted.")
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Println("rewrite:", err)
+			os.Exit(2)
+		}
+	}
+	stop()
 
+	for _, path := range slices.Sorted(maps.Keys(result.missing)) {
+		fmt.Printf("Missing metadata: %s (%s)\n", path, strings.Join(result.missing[path], ", "))
+	}
 	if cfg.withDryRun {
 		fmt.Printf("Found %d file(s) to %s!\n", len(pairs), actionName)
+		if len(result.duplicates) > 0 {
+			fmt.Printf("Found %d duplicate(s) to leave alone.\n", len(result.duplicates))
+		}
 		if cfg.withVerbose {
-			for k, v := range pairs {
+			for _, k := range inOrder(pairs, result.order) {
+				v := pairs[k]
+				if target, ok := result.links[v]; ok {
+					fmt.Printf("%s -> %s (link to %s)\n", k, v, target)
+					continue
+				}
 				fmt.Printf("%s -> %s\n", k, v)
 			}
+			for _, k := range slices.Sorted(maps.Keys(result.duplicates)) {
+				fmt.Printf("%s == %s\n", k, result.duplicates[k])
+			}
+		}
+		if len(rewritePatterns) > 0 {
+			var count int
+			for _, r := range rewrites {
+				count += r.count
+			}
+			fmt.Printf("Found %d reference(s) to update in %d file(s).\n", count, len(rewrites))
+			for _, r := range rewrites {
+				fmt.Print(r.diff())
+			}
 		}
 		return
 	}
+	if cfg.options.dedupe == REPORT {
+		for _, k := range slices.Sorted(maps.Keys(result.duplicates)) {
+			fmt.Printf("Duplicate: %s == %s\n", k, result.duplicates[k])
+		}
+	}
 	if cfg.withInteractive {
 		fmt.Printf("Found %d file(s) to %s. Proceed?(y/n) ", len(pairs), actionName)
 		if !canProceed() {
@@ -79,207 +406,621 @@ func main() {
 		}
 	}
 
-	start := time.Now()
-	var n uint
-	if cfg.options.output != "" {
-		tt := getTransmissionType(cfg.options.transmissionType)
-		var n uint
-		var err error
-		var message, vMessage string
-		if tt == COPY {
-			n, err = copyAction(pairs)
-			message = fmt.Sprintf("%d file(s) were copied.", n)
-			vMessage = fmt.Sprintf("Copied %d file(s)", n)
+	if arc != nil {
+		os.Exit(applyArchive(arc, pairs, archiveOutput, allowsOverwrite(cfg.options.onConflict), cfg.withVerbose))
+	}
 
-		} else {
-			n, err = moveAction(pairs)
-			message = fmt.Sprintf("%d file(s) were moved.", n)
-			vMessage = fmt.Sprintf("Moved %d file(s)", n)
+	opts := actionOptions{
+		overwrite: allowsOverwrite(cfg.options.onConflict),
+		links:     result.links,
+		order:     result.order,
+		verify:    cfg.options.verify,
+		retries:   cfg.options.verifyRetries,
+		reflink:   cfg.options.reflink,
+		fsys:      cfg.fsys,
+		git:       cfg.git,
+		roots:     roots,
+	}
+	for _, r := range rewrites {
+		opts.rewrites = append(opts.rewrites, r.pending())
+	}
+	os.Exit(apply(actionName, pairs, opts, cfg.withVerbose))
+}
+
+// sweep cleans up after earlier runs that crashed or were killed under the
+// roots: partial copies are removed, and files parked mid-cycle put back
+// under their names.
+func sweep(fsys fileSystem, roots []string, verbose bool) {
+	var removed, restored, left int
+	for _, root := range roots {
+		if root == "" {
+			continue
 		}
+		n, err := removePartials(fsys, root)
 		if err != nil {
-			fmt.Printf("%s: %t", tt, err)
-			fmt.Println(message)
+			fmt.Println("remove partial copies:", err)
 			os.Exit(2)
 		}
-		if cfg.withVerbose {
-			fmt.Printf("%s in %s.\n", vMessage, time.Since(start))
-		}
-	} else {
-		n, err = renameAction(pairs)
+		removed += n
+		r, l, err := restoreParked(fsys, root)
 		if err != nil {
-			fmt.Println("Renaming:", err)
-			fmt.Printf("%d file(s) were renamed.\n", n)
+			fmt.Println("restore parked files:", err)
 			os.Exit(2)
 		}
-		if cfg.withVerbose {
-			fmt.Printf("Renamed %d file(s) in %s.\n", n, time.Since(start))
+		restored, left = restored+r, left+l
+	}
+	if removed > 0 && verbose {
+		fmt.Printf("Removed %d partial copies left by an earlier run.\n", removed)
+	}
+	if restored > 0 && verbose {
+		fmt.Printf("Restored %d file(s) an earlier run left parked.\n", restored)
+	}
+	if left > 0 {
+		fmt.Printf("%d file(s) an earlier run left parked have their names taken, run with -resume to finish them.\n", left)
+	}
+}
+
+// resume applies the pairs an earlier run over roots left unfinished.
+func resume(roots []string, verbose bool) {
+	path, err := findState(roots)
+	if err != nil {
+		fmt.Println("resume:", err)
+		os.Exit(1)
+	}
+	state, err := loadState(path)
+	if err != nil {
+		fmt.Println("resume:", err)
+		os.Exit(1)
+	}
+	opts := state.options()
+	switch {
+	case state.S3Endpoint != "":
+		opts.fsys, err = newS3FS(state.S3Endpoint)
+	case state.SFTPHost != "":
+		opts.fsys, err = dialSFTP(state.SFTPHost)
+	case state.Git != "":
+		opts.git, err = openGitTree(state.Git, false)
+	}
+	if err != nil {
+		fmt.Println("resume:", err)
+		os.Exit(2)
+	}
+	if state.Action != COPY {
+		if err := unpark(orOS(opts.fsys), state.Pairs); err != nil {
+			fmt.Println("resume:", err)
+			os.Exit(2)
 		}
 	}
+	fmt.Printf("Resuming %d file(s) to %s.\n", len(state.Pairs), state.Action)
+	os.Exit(apply(state.Action, state.Pairs, opts, verbose))
 }
 
-func walker(config config, pattern *regexp.Regexp,
-) (map[string]string, error) {
-	pairs := make(map[string]string)
-	err := filepath.WalkDir(
+// applyArchive writes the archive with its entries renamed, in place or
+// into the output dir, and returns the exit code.
+func applyArchive(arc *archive, pairs map[string]string, output string, overwrite, verbose bool) int {
+	ctx, stop := interruptible()
+	defer stop()
+
+	dst := arc.path
+	if output != "" {
+		dst = filepath.Join(output, filepath.Base(arc.path))
+		if err := makeParent(osFS{}, dst); err != nil {
+			fmt.Println("archive:", err)
+			return 2
+		}
+	} else {
+		// Replacing the archive in place is the point of renaming in it.
+		overwrite = true
+	}
+	start := time.Now()
+	n, err := arc.write(ctx, pairs, dst, overwrite)
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("\nInterrupted, the archive was left as it was.")
+		return 2
+	}
+	if err != nil {
+		fmt.Println("archive:", err)
+		return 2
+	}
+	if verbose {
+		fmt.Printf("Renamed %d entries of %s in %s.\n", n, dst, time.Since(start))
+	}
+	return 0
+}
+
+// interruptible returns a context the first interrupt ends. A second one
+// kills the process as usual.
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
+
+// apply runs the action over pairs and returns the exit code. The first
+// interrupt stops the run, dropping a copy part way, and a second one kills
+// it. The pairs are saved for -resume before any is applied, and each one
+// logged once done, so what is left can be finished even if the process is
+// killed.
+func apply(action string, pairs map[string]string, opts actionOptions, verbose bool) int {
+	ctx, stop := interruptible()
+	defer stop()
+
+	opts.done = make(map[string]bool)
+	path := statePath(action, opts.roots)
+	// The state goes first, so a kill before the log is cleared leaves the
+	// new pairs to resume, not the old ones with nothing logged.
+	if err := saveState(path, newRunState(action, pairs, opts)); err != nil {
+		fmt.Println("save progress:", err)
+		return 2
+	}
+	progress, err := createProgress(progressPath(path))
+	if err != nil {
+		fmt.Println("save progress:", err)
+		return 2
+	}
+	opts.progress = progress
+	start := time.Now()
+	var n uint
+	switch action {
+	case COPY:
+		n, err = copyAction(ctx, pairs, opts)
+	case MOVE:
+		n, err = moveAction(ctx, pairs, opts)
+	default:
+		n, err = renameAction(ctx, pairs, opts)
+	}
+
+	if err := progress.close(); err != nil {
+		fmt.Println("save progress:", err)
+	}
+
+	past := map[string]string{COPY: "copied", MOVE: "moved", RENAME: "renamed"}[action]
+	var rewriting bool
+	if err == nil {
+		if verbose {
+			fmt.Printf("%s %d file(s) in %s.\n", strings.ToUpper(past[:1])+past[1:], n, time.Since(start))
+		}
+		err = applyRewrites(orOS(opts.fsys), opts.rewrites)
+		rewriting = err != nil
+	}
+	if err == nil {
+		removeState(path)
+		if verbose && len(opts.rewrites) > 0 {
+			fmt.Printf("Updated references in %d file(s).\n", len(opts.rewrites))
+		}
+		return stageInGit(action, pairs, opts, verbose)
+	}
+
+	switch {
+	case rewriting:
+		fmt.Println("rewrite:", err)
+	case errors.Is(err, context.Canceled):
+		fmt.Println("\nInterrupted.")
+	case action == RENAME:
+		fmt.Println("Renaming:", err)
+	default:
+		fmt.Printf("%s: %v\n", action, err)
+	}
+	fmt.Printf("%d file(s) were %s.\n", n, past)
+	// What was done is staged even though the run stopped part way, so the
+	// index matches the files.
+	stageInGit(action, pairs, opts, verbose)
+	state := newRunState(action, pairs, opts)
+	if err := saveState(path, state); err != nil {
+		fmt.Println("save progress:", err)
+		return 2
+	}
+	// The state now leaves out what was done.
+	_ = os.Remove(progressPath(path))
+	if len(state.Rewrites) > 0 {
+		fmt.Printf("%d file(s) left, and the references in %d file(s), run again with -resume to finish them.\n", len(state.Pairs), len(state.Rewrites))
+		return 2
+	}
+	fmt.Printf("%d file(s) left, run again with -resume to finish them.\n", len(state.Pairs))
+	return 2
+}
+
+// stageInGit stages the renames and moves that are done in the working
+// tree, if there is one, and returns the exit code.
+func stageInGit(action string, pairs map[string]string, opts actionOptions, verbose bool) int {
+	if opts.git == nil || action == COPY {
+		return 0
+	}
+	n, err := opts.git.stage(pairs, opts.done)
+	if err != nil {
+		fmt.Println("git:", err)
+		return 2
+	}
+	if verbose {
+		fmt.Printf("Staged %d file(s) in git.\n", n)
+	}
+	return 0
+}
+
+// walker plans the files under config.options.path, adding them to the plan
+// of config.resolver if there is one. Once ctx is done it stops with a
+// cancelledError, returning what it had planned by then.
+func walker(ctx context.Context, config config, pattern *regexp.Regexp,
+) (plan, error) {
+	fsys := orOS(config.fsys)
+	resolver := config.resolver
+	if resolver == nil {
+		resolver = newConflictResolver(
+			fsys, config.options.onConflict, config.options.suffixFormat, config.options.dedupe,
+		)
+	}
+	result := resolver.result
+	var files []*candidate
+	err := fsys.WalkDir(
 		config.options.path,
 		func(path string, file fs.DirEntry, err error) error {
+			if err := stopped(ctx, "walk", uint(len(files))); err != nil {
+				return err
+			}
 			switch {
 			case err != nil:
 				return err
+			case file.IsDir() && config.git != nil && file.Name() == ".git":
+				return fs.SkipDir
 			case file.IsDir():
 				return nil
 			}
 			oldName := file.Name()
+			if isPartial(oldName) || isParked(oldName) {
+				return nil
+			}
+			if config.git != nil && !config.git.includes(path) {
+				return nil
+			}
 			fileExt := filepath.Ext(oldName)
 			if config.options.fileType != "" && fileExt != "" {
 				if fileExt != config.options.fileType {
 					return nil
 				}
 			}
+			if ok, err := config.filter.match(fsys, path, file); !ok {
+				return err
+			}
+			// A string to find limits the files the chain renames too.
 			targetStr := searchString(pattern, config.options.str, oldName)
-			if config.withRegex && targetStr == "" {
+			switch {
+			case config.withRegex && targetStr == "":
 				return nil
-			}
-
-			newName := strings.ReplaceAll(oldName, targetStr, config.options.replace)
-			if newName == oldName || newName == "" {
+			case !config.withRegex && !strings.Contains(oldName, targetStr):
 				return nil
 			}
 
-			var targetDir string
-			if config.options.output != "" {
-				targetDir = config.options.output
-			} else {
-				targetDir = path
-			}
-			if config.options.replace != "" {
-				newName = resolveConflict(filepath.Dir(targetDir), newName, pairs)
+			src := &source{path: path, fsys: fsys}
+			if len(config.chain.hashes) > 0 {
+				if src.digests, err = hashFile(ctx, fsys, path, config.chain.hashes); err != nil {
+					return cmp.Or(stopped(ctx, "walk", uint(len(files))), err)
+				}
 			}
-			newPath := filepath.Join(filepath.Dir(targetDir), newName)
-			if path == newPath {
-				return nil
+
+			newName := oldName
+			if targetStr != "" {
+				newName = strings.ReplaceAll(oldName, targetStr, config.options.replace)
 			}
-			pairs[path] = newPath
+			files = append(files, &candidate{src: src, name: newName})
 			return nil
 		})
-	return pairs, err
+	if err != nil {
+		return *result, err
+	}
+	sortCandidates(files, config.options.sortBy)
+
+	// The chain runs once every file is known, as renumbering needs all
+	// the names of a sequence.
+	config.chain.apply(files)
+
+	targets := make([]string, len(files))
+	for i, f := range files {
+		if len(f.src.missing) > 0 {
+			result.missing[f.src.path] = f.src.missing
+		}
+		if targets[i], err = targetPath(config, f); err != nil {
+			return *result, err
+		}
+	}
+
+	// Renamed and moved files free their paths, so a file may take the
+	// name of another one that moves on, as when a sequence shifts down.
+	if config.options.output == "" || getTransmissionType(config.options.transmissionType) == MOVE {
+		for i, f := range files {
+			if targets[i] != "" {
+				resolver.leaving[f.src.path] = true
+			}
+		}
+	}
+	for i, f := range files {
+		if targets[i] == "" {
+			continue
+		}
+		planned := uint(len(result.pairs))
+		if err := stopped(ctx, "walk", planned); err != nil {
+			return *result, err
+		}
+		if err := resolver.add(ctx, f.src, targets[i]); err != nil {
+			return *result, cmp.Or(stopped(ctx, "walk", planned), err)
+		}
+	}
+	if err := resolver.settle(ctx); err != nil {
+		return *result, cmp.Or(stopped(ctx, "walk", uint(len(result.pairs))), err)
+	}
+	for _, f := range files {
+		if _, ok := result.pairs[f.src.path]; ok {
+			result.order = append(result.order, f.src.path)
+		}
+	}
+	if config.options.output != "" {
+		if err := linkHardLinks(fsys, *result); err != nil {
+			return *result, err
+		}
+	}
+	return *result, nil
+}
+
+// linkHardLinks keeps files hard-linked to each other linked in the output:
+// the first of each group is written, and the others are linked to it.
+func linkHardLinks(fsys fileSystem, p plan) error {
+	firsts := make(map[inode]string)
+	for _, src := range p.order {
+		dst, ok := p.pairs[src]
+		if !ok {
+			continue
+		}
+		if _, ok := p.links[dst]; ok {
+			continue
+		}
+		info, err := fsys.Lstat(src)
+		if err != nil {
+			return fmt.Errorf("get file(%q) info: %w", src, err)
+		}
+		id, ok := hardLinked(info)
+		if !ok {
+			continue
+		}
+		if first, ok := firsts[id]; ok {
+			p.links[dst] = first
+			continue
+		}
+		firsts[id] = dst
+	}
+	return nil
 }
 
-func copyAction(pairs map[string]string) (uint, error) {
+// targetPath returns where the file goes with its new name, or an empty path
+// if it is left where it is.
+func targetPath(config config, f *candidate) (string, error) {
+	path := f.src.path
+	oldName := filepath.Base(path)
+	newName := sanitizePath(f.name, config.options.sanitize)
+	if newName == oldName || newName == "" {
+		return "", nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(newName)) {
+		return "", fmt.Errorf("%q: new name %q leaves the target directory", path, newName)
+	}
+
+	targetDir := filepath.Dir(path)
+	if config.options.output != "" {
+		targetDir = config.options.output
+		if config.options.keepTree {
+			rel, err := filepath.Rel(config.options.path, filepath.Dir(path))
+			if err != nil {
+				return "", err
+			}
+			targetDir = filepath.Join(targetDir, rel)
+		}
+	}
+	newPath := filepath.Join(targetDir, filepath.FromSlash(newName))
+	if path == newPath {
+		return "", nil
+	}
+	return newPath, nil
+}
+
+func copyAction(ctx context.Context, pairs map[string]string, opts actionOptions) (uint, error) {
 	r, err := ravan.New(ravan.WithWidth(50))
 	if err != nil {
 		return 0, fmt.Errorf("init raven: %w", err)
 	}
 
+	fsys := orOS(opts.fsys)
 	var copied uint
 	total := len(pairs)
-	for oldName, newName := range pairs {
-		if err := copyFile(oldName, newName); err != nil {
-			return copied, fmt.Errorf("%q to %q: %w", oldName, newName, err)
+	for _, oldName := range linksLast(pairs, opts.links, opts.order) {
+		if err := stopped(ctx, COPY, copied); err != nil {
+			return copied, err
+		}
+		newName := pairs[oldName]
+		if err := makeParent(fsys, newName); err != nil {
+			return copied, fmt.Errorf("%q: %w", newName, err)
+		}
+		var err error
+		if target, ok := opts.links[newName]; ok {
+			err = fsys.Link(target, newName)
+		} else {
+			err = copyFile(ctx, oldName, newName, opts)
+			if err == nil && opts.verify {
+				err = verifyCopy(ctx, oldName, newName, opts)
+			}
+		}
+		if err != nil {
+			return copied, cmp.Or(stopped(ctx, COPY, copied), fmt.Errorf("%q to %q: %w", oldName, newName, err))
 		}
+		opts.markDone(oldName)
 		copied++
 		r.Draw(float64(copied) / float64(total))
 	}
 	return copied, nil
 }
 
-func moveAction(pairs map[string]string) (uint, error) {
+func moveAction(ctx context.Context, pairs map[string]string, opts actionOptions) (uint, error) {
 	r, err := ravan.New(ravan.WithWidth(50))
 	if err != nil {
 		return 0, fmt.Errorf("init raven: %w", err)
 	}
 
+	fsys := orOS(opts.fsys)
 	var moved uint
 	total := len(pairs)
-	for oldName, newName := range pairs {
-		if err := moveFile(oldName, newName); err != nil {
-			return moved, fmt.Errorf("%q to %q: %w", oldName, newName, err)
+	moves := chainOrder(pairs, opts.links, opts.order)
+	for i, m := range moves {
+		// A file parked under a temporary name is always moved on.
+		fileCtx := ctx
+		if midCycle(moves, i) {
+			fileCtx = context.WithoutCancel(ctx)
+		} else if err := stopped(ctx, MOVE, moved); err != nil {
+			return moved, err
+		}
+		oldName, newName := m.src, m.dst
+		if err := makeParent(fsys, newName); err != nil {
+			return moved, fmt.Errorf("%q: %w", newName, err)
+		}
+		var err error
+		if target, ok := opts.links[newName]; ok {
+			err = linkAndRemove(fsys, target, newName, oldName)
+		} else {
+			err = moveFile(fileCtx, oldName, newName, opts)
 		}
+		if err != nil {
+			return moved, cmp.Or(stopped(fileCtx, MOVE, moved), fmt.Errorf("%q to %q: %w", oldName, newName, err))
+		}
+		if m.temp {
+			continue
+		}
+		opts.markDone(m.origin)
 		moved++
 		r.Draw(float64(moved) / float64(total))
 	}
 	return moved, nil
 }
 
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
+// linksLast returns the sources of pairs in order, with the ones whose
+// destination is hard-linked after the others, so the files they link to
+// exist by then.
+func linksLast(pairs, links map[string]string, order []string) []string {
+	sources := make([]string, 0, len(pairs))
+	var linked []string
+	for _, src := range inOrder(pairs, order) {
+		if _, ok := links[pairs[src]]; ok {
+			linked = append(linked, src)
+			continue
+		}
+		sources = append(sources, src)
+	}
+	return append(sources, linked...)
+}
+
+// linkAndRemove moves src, a duplicate of target, by linking target to dst.
+func linkAndRemove(fsys fileSystem, target, dst, src string) error {
+	if err := fsys.Link(target, dst); err != nil {
+		return err
+	}
+	if err := fsys.Remove(src); err != nil {
+		return fmt.Errorf("remove source file after link: %w", err)
+	}
+	return nil
+}
+
+// copyFile copies src to dst, stopping part way, with nothing written to
+// dst, once ctx is done.
+func copyFile(ctx context.Context, src, dst string, opts actionOptions) error {
+	fsys := orOS(opts.fsys)
+	if c, ok := fsys.(copier); ok {
+		if err := c.Copy(src, dst, opts.overwrite); err != nil {
+			return fmt.Errorf("copying data: %w", err)
+		}
+		return nil
+	}
+	in, err := fsys.Open(src)
 	if err != nil {
 		return fmt.Errorf("open source file: %w", err)
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	out, err := createPartial(fsys, dst)
 	if err != nil {
 		return fmt.Errorf("create destination file: %w", err)
 	}
-	defer out.Close()
+	defer out.discard()
 
-	if _, err = io.Copy(out, in); err != nil {
+	if err = copyData(ctx, out.writeFile, in, opts.reflink); err != nil {
 		return fmt.Errorf("copying data: %w", err)
 	}
 
-	if err = out.Sync(); err != nil {
-		return fmt.Errorf("sync destination file: %w", err)
-	}
-
-	info, err := os.Stat(src)
+	info, err := fsys.Stat(src)
 	if err != nil {
 		return fmt.Errorf("failed to get file(%q) info: %w", src, err)
 	}
-	if err = os.Chmod(dst, info.Mode()); err != nil {
+	if err = out.Chmod(info.Mode()); err != nil {
 		return fmt.Errorf("failed to set file(%q) permissions: %w", dst, err)
 	}
 
-	return nil
-}
-
-func moveFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("open source file: %w", err)
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
+	if err = out.commit(opts.overwrite); err != nil {
 		return fmt.Errorf("create destination file: %w", err)
 	}
-	defer out.Close()
+	return nil
+}
 
-	if _, err = io.Copy(out, in); err != nil {
-		return fmt.Errorf("moving data: %w", err)
+// moveFile copies src to dst and removes src, only once the copy is verified
+// if opts asks for it. File systems that move by renaming do so instead.
+func moveFile(ctx context.Context, src, dst string, opts actionOptions) error {
+	fsys := orOS(opts.fsys)
+	if caseVariant(fsys, src, dst) {
+		// Copying the file onto itself would lose it.
+		return fsys.Rename(src, dst, opts.overwrite)
 	}
-	if err = out.Sync(); err != nil {
-		return fmt.Errorf("sync destination file: %w", err)
+	if m, ok := fsys.(mover); ok {
+		err := m.Move(src, dst, opts.overwrite)
+		if err == nil || errors.Is(err, fs.ErrExist) {
+			return err
+		}
 	}
-	info, err := os.Stat(src)
+	err := copyFile(ctx, src, dst, opts)
 	if err != nil {
-		return fmt.Errorf("get file(%q) info: %w", src, err)
+		return err
 	}
-	if err = os.Chmod(dst, info.Mode()); err != nil {
-		return fmt.Errorf("set file(%q) permissions: %w", dst, err)
+	if opts.verify {
+		if err = verifyCopy(ctx, src, dst, opts); err != nil {
+			return err
+		}
 	}
-	if err = os.Remove(src); err != nil {
+	if err = fsys.Remove(src); err != nil {
 		return fmt.Errorf("remove source file after copy: %w", err)
 	}
 
 	return nil
 }
 
-func renameAction(pairs map[string]string) (uint, error) {
+func renameAction(ctx context.Context, pairs map[string]string, opts actionOptions) (uint, error) {
 	r, err := ravan.New(ravan.WithWidth(50))
 	if err != nil {
 		return 0, fmt.Errorf("init raven: %w", err)
 	}
 
+	fsys := orOS(opts.fsys)
 	var renamed uint
 	total := len(pairs)
-	for oldName, newName := range pairs {
-		if err := os.Rename(oldName, newName); err != nil {
+	moves := chainOrder(pairs, nil, opts.order)
+	for i, m := range moves {
+		if err := stopped(ctx, RENAME, renamed); err != nil && !midCycle(moves, i) {
+			return renamed, err
+		}
+		oldName, newName := m.src, m.dst
+		if err := makeParent(fsys, newName); err != nil {
+			return renamed, fmt.Errorf("%q: %w", newName, err)
+		}
+		if err := fsys.Rename(oldName, newName, opts.overwrite); err != nil {
 			return renamed, fmt.Errorf(
 				"%q to %q: %w", oldName, newName, err,
 			)
 		}
+		if m.temp {
+			continue
+		}
+		opts.markDone(m.origin)
 		renamed++
 		r.Draw(float64(renamed) / float64(total))
 	}
@@ -287,21 +1028,75 @@ func renameAction(pairs map[string]string) (uint, error) {
 	return renamed, nil
 }
 
-func parseFlags() config {
+func parseFlags() (config, error) {
+	return parseArgs(flag.CommandLine, os.Args[1:])
+}
+
+// parseArgs parses args on fs, on top of the rules file they name if any.
+func parseArgs(fs *flag.FlagSet, args []string) (config, error) {
 	var cfg config
-	flag.StringVar(&cfg.options.path, "p", "", "path to dir")
-	flag.StringVar(&cfg.options.str, "s", "", "string to find")
-	flag.StringVar(&cfg.options.fileType, "t", "", "filter file type to modify")
-	flag.StringVar(&cfg.options.replace, "replace", "", "replace str instead of remove it")
-	flag.StringVar(&cfg.options.output, "output", "", "copy to new dir instead of rename in path flag dir")
-	flag.StringVar(&cfg.options.transmissionType, "tt", "", "determine transmission type. default is copy if output flag is exist.")
-	flag.BoolVar(&cfg.withVerbose, "v", false, "verbose")
-	flag.BoolVar(&cfg.withDryRun, "d", false, "dry run")
-	flag.BoolVar(&cfg.withInteractive, "i", false, "interactive")
-	flag.BoolVar(&cfg.withRegex, "r", false, "enable regex")
-	flag.BoolVar(&cfg.help, "help", false, "help")
-	flag.Parse()
-	return cfg
+	registerFlags(fs, &cfg)
+	_ = fs.Parse(args)
+	if cfg.rules == "" {
+		return cfg, nil
+	}
+
+	// Parse the flags once more on top of the rules, so the ones given on
+	// the command line take precedence.
+	rules, err := loadRules(cfg.rules)
+	if err != nil {
+		return cfg, err
+	}
+	// Repeated -op flags add up, so those given replace the rules' chain
+	// rather than follow it.
+	if len(cfg.options.operations) > 0 {
+		rules.options.operations = nil
+	}
+	again := flag.NewFlagSet(fs.Name(), flag.ExitOnError)
+	registerFlags(again, &rules)
+	_ = again.Parse(args)
+	return rules, nil
+}
+
+// registerFlags defines the flags on fs, using the values already in cfg as
+// their defaults.
+func registerFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.options.path, "p", cfg.options.path, "path to dir")
+	fs.StringVar(&cfg.options.str, "s", cfg.options.str, "string to find")
+	fs.StringVar(&cfg.options.fileType, "t", cfg.options.fileType, "filter file type to modify")
+	fs.StringVar(&cfg.options.filters.minSize, "min-size", cfg.options.filters.minSize, "filter files at least this size, like 512k or 1.5G")
+	fs.StringVar(&cfg.options.filters.maxSize, "max-size", cfg.options.filters.maxSize, "filter files at most this size")
+	fs.StringVar(&cfg.options.filters.newerThan, "newer-than", cfg.options.filters.newerThan, "filter files newer than an age, like 36h, 7d or 2w")
+	fs.StringVar(&cfg.options.filters.olderThan, "older-than", cfg.options.filters.olderThan, "filter files older than an age")
+	fs.StringVar(&cfg.options.filters.ageBy, "age-by", cmp.Or(cfg.options.filters.ageBy, MTIME), "time the age filters look at (mtime, btime)")
+	fs.StringVar(&cfg.options.filters.owner, "owner", cfg.options.filters.owner, "filter files owned by a user name or uid")
+	fs.StringVar(&cfg.options.filters.group, "group", cfg.options.filters.group, "filter files owned by a group name or gid")
+	fs.StringVar(&cfg.options.filters.perm, "perm", cfg.options.filters.perm, "filter files by permission bits: 644 exactly, -111 all of, /222 any of")
+	fs.StringVar(&cfg.options.filters.kind, "kind", cfg.options.filters.kind, "filter files by kind, comma separated (regular, symlink, fifo, socket, device, char)")
+	fs.StringVar(&cfg.options.replace, "replace", cfg.options.replace, "replace str instead of remove it")
+	fs.StringVar(&cfg.options.output, "output", cfg.options.output, "copy to new dir instead of rename in path flag dir")
+	fs.BoolVar(&cfg.options.keepTree, "keep-tree", cfg.options.keepTree, "keep the folders of the files under the output dir")
+	fs.StringVar(&cfg.options.reflink, "reflink", cmp.Or(cfg.options.reflink, AUTO), "clone copies on copy-on-write file systems (auto, always, never)")
+	fs.StringVar(&cfg.options.s3Endpoint, "s3-endpoint", cmp.Or(cfg.options.s3Endpoint, defaultS3Endpoint), "URL of the S3 compatible storage s3:// paths are in")
+	fs.BoolVar(&cfg.options.git, "git", cfg.options.git, "rename only the files git tracks, and stage the renames")
+	fs.BoolVar(&cfg.options.gitUntracked, "git-untracked", cfg.options.gitUntracked, "with -git, rename the files git doesn't track too, unless they are ignored")
+	fs.StringVar(&cfg.options.rewrite, "rewrite", cfg.options.rewrite, "update references to renamed files in the text files matching these comma separated patterns, like \"*.md,*.html\"")
+	fs.BoolVar(&cfg.options.verify, "verify", cfg.options.verify, "verify copies and moves against the source by checksum")
+	fs.IntVar(&cfg.options.verifyRetries, "verify-retries", cfg.options.verifyRetries, "copy again this many times when verification fails")
+	fs.StringVar(&cfg.options.transmissionType, "tt", cfg.options.transmissionType, "determine transmission type. default is copy if output flag is exist.")
+	fs.Var(&cfg.options.operations, "op", "operation to chain, repeatable (remove, replace, regex, case, trim, insert, sanitize, template, renumber)")
+	fs.StringVar(&cfg.options.sanitize, "sanitize", cfg.options.sanitize, "make names portable for a target profile (posix, windows, smb, s3)")
+	fs.StringVar(&cfg.options.onConflict, "on-conflict", cmp.Or(cfg.options.onConflict, SUFFIX), "what to do when a name is taken (skip, overwrite, suffix, fail, keep-newer, keep-larger)")
+	fs.StringVar(&cfg.options.suffixFormat, "suffix-format", cmp.Or(cfg.options.suffixFormat, defaultSuffixFormat), "numbered suffix for the suffix policy, like \" (%d)\" or \"-v%d\"")
+	fs.StringVar(&cfg.options.sortBy, "sort", cfg.options.sortBy, "order the plan is built and applied in (path, name, mtime, size)")
+	fs.StringVar(&cfg.options.dedupe, "dedupe", cfg.options.dedupe, "compare content of colliding files, and skip, link or report the duplicates")
+	fs.StringVar(&cfg.rules, "c", cfg.rules, "load options from a rules file (.yaml or .toml)")
+	fs.BoolVar(&cfg.withVerbose, "v", cfg.withVerbose, "verbose")
+	fs.BoolVar(&cfg.withDryRun, "d", cfg.withDryRun, "dry run")
+	fs.BoolVar(&cfg.withInteractive, "i", cfg.withInteractive, "interactive")
+	fs.BoolVar(&cfg.withRegex, "r", cfg.withRegex, "enable regex")
+	fs.BoolVar(&cfg.resume, "resume", cfg.resume, "pick up the files an interrupted or failed run left")
+	fs.BoolVar(&cfg.help, "help", cfg.help, "help")
 }
 
 func searchString(pattern *regexp.Regexp, str, fileName string) string {
@@ -311,6 +1106,16 @@ func searchString(pattern *regexp.Regexp, str, fileName string) string {
 	return pattern.FindString(fileName)
 }
 
+// markDone records that the action is done with src.
+func (o actionOptions) markDone(src string) {
+	if o.done != nil {
+		o.done[src] = true
+	}
+	if o.progress != nil {
+		o.progress.add(absPath(o.fsys, src))
+	}
+}
+
 func canProceed() bool {
 	r := bufio.NewReader(os.Stdin)
 	s, err := r.ReadString('\n')
@@ -325,31 +1130,6 @@ func canProceed() bool {
 	}
 }
 
-func resolveConflict(dir, newName string, pairs map[string]string) string {
-	candidate := newName
-	count := 1
-	for {
-		conflict := false
-		for _, v := range pairs {
-			if filepath.Base(v) == candidate {
-				conflict = true
-				break
-			}
-		}
-		if _, err := os.Stat(filepath.Join(dir, candidate)); err == nil {
-			conflict = true
-		}
-		if !conflict {
-			break
-		}
-		ext := filepath.Ext(newName)
-		nameOnly := strings.TrimSuffix(newName, ext)
-		candidate = fmt.Sprintf("%s_%d%s", nameOnly, count, ext)
-		count++
-	}
-	return candidate
-}
-
 func getActionName(output, tType string) string {
 	tt := getTransmissionType(tType)
 	name := RENAME
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -39,10 +40,11 @@ func TestWalkerWithoutRegex(t *testing.T) {
 	}
 
 	// Call walker with regex disabled (pattern is nil) and str "target".
-	pairs, err := walker(cfg, nil)
+	result, err := walker(context.Background(), cfg, nil)
 	if err != nil {
 		t.Fatalf("walker error: %v", err)
 	}
+	pairs := result.pairs
 	// file1 should be processed because it contains "target".
 	if _, ok := pairs[file1]; !ok {
 		t.Errorf("expected file %s to be in pairs", file1)
@@ -82,10 +84,11 @@ func TestWalkerWithRegex(t *testing.T) {
 
 	// Here the second parameter "target" is still passed,
 	// but the searchString function uses the regex if provided.
-	pairs, err := walker(cfg, pattern)
+	result, err := walker(context.Background(), cfg, pattern)
 	if err != nil {
 		t.Fatalf("walker error: %v", err)
 	}
+	pairs := result.pairs
 
 	// file1 should be processed because it matches the regex.
 	if _, ok := pairs[file1]; !ok {
@@ -97,7 +100,8 @@ func TestWalkerWithRegex(t *testing.T) {
 	}
 
 	// Verify that the new file name is as expected.
-	expectedNewName := "example.txt" // "example_target.txt" with "_target" removed.
+	// "example_target.txt" with "_target" removed, suffixed since file2 already takes "example.txt".
+	expectedNewName := "example_1.txt"
 	newPath, ok := pairs[file1]
 	if !ok {
 		t.Fatalf("file %s not found in pairs", file1)
@@ -131,10 +135,11 @@ func TestWalkerWitFileType(t *testing.T) {
 	}
 
 	// Call walker with regex disabled (pattern is nil) and str "target".
-	pairs, err := walker(cfg, nil)
+	result, err := walker(context.Background(), cfg, nil)
 	if err != nil {
 		t.Fatalf("walker error: %v", err)
 	}
+	pairs := result.pairs
 	// file1 should not be processed because it contains ".txt" instead of ".json".
 	if _, ok := pairs[file1]; ok {
 		t.Errorf("did not expect file %s in pairs", file1)
@@ -189,10 +194,11 @@ func TestCollisionResolution(t *testing.T) {
 	}
 
 	// Call walker to generate the mapping of old paths to new paths.
-	pairs, err := walker(cfg, pattern)
+	result, err := walker(context.Background(), cfg, pattern)
 	if err != nil {
 		t.Fatalf("walker error: %v", err)
 	}
+	pairs := result.pairs
 
 	// We expect both files to be processed.
 	if len(pairs) != 2 {
@@ -233,7 +239,7 @@ func TestRenameAction(t *testing.T) {
 	}
 
 	// Call renameAction.
-	count, err := renameAction(pairs)
+	count, err := renameAction(context.Background(), pairs, actionOptions{})
 	if err != nil {
 		t.Fatalf("rename error: %v", err)
 	}
@@ -275,7 +281,7 @@ func TestCopyAction(t *testing.T) {
 	}
 
 	// Call copyAction.
-	count, err := copyAction(pairs)
+	count, err := copyAction(context.Background(), pairs, actionOptions{})
 	if err != nil {
 		t.Fatalf("copy error: %v", err)
 	}
@@ -313,7 +319,7 @@ func TestMoveAction(t *testing.T) {
 	}
 
 	// Call moveAction.
-	count, err := moveAction(pairs)
+	count, err := moveAction(context.Background(), pairs, actionOptions{})
 	if err != nil {
 		t.Fatalf("move error: %v", err)
 	}
@@ -444,7 +450,7 @@ func TestCopyFile(t *testing.T) {
 	file1 := createTempFile(t, srcDir, fileName, fileContent)
 
 	newPath := filepath.Join(dstDir, fileName)
-	if err := copyFile(file1, newPath); err != nil {
+	if err := copyFile(context.Background(), file1, newPath, actionOptions{}); err != nil {
 		t.Errorf("expected copy %q to %q", file1, newPath)
 	}
 
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// mediaInfo is the metadata read from a photo or a video.
+type mediaInfo struct {
+	taken time.Time
+	make  string
+	model string
+}
+
+// errNoMetadata is returned for files of a format that isn't read, or that
+// carry no metadata.
+var errNoMetadata = errors.New("no media metadata")
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// maxExifSize bounds how much is read looking for the EXIF block.
+const maxExifSize = 1 << 20
+
+// readMedia reads the capture time and camera from JPEG, PNG and TIFF based
+// files (which most raw formats are), or the creation time from MP4 and
+// QuickTime files. The format is sniffed from the content.
+func readMedia(fsys fileSystem, path string) (mediaInfo, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return mediaInfo{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return mediaInfo{}, errNoMetadata
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return mediaInfo{}, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8}):
+		return readJPEG(f)
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		block, err := io.ReadAll(io.LimitReader(f, maxExifSize))
+		if err != nil {
+			return mediaInfo{}, err
+		}
+		return parseTIFF(block)
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return readPNG(f)
+	case isBMFF(head):
+		return readBMFF(f)
+	default:
+		return mediaInfo{}, errNoMetadata
+	}
+}
+
+// readJPEG looks for the EXIF block in the APP1 segments, up to the start of
+// the image data.
+func readJPEG(r io.Reader) (mediaInfo, error) {
+	br := &byteReader{r: r}
+	br.skip(2)
+	for br.err == nil {
+		if br.byte() != 0xff {
+			break
+		}
+		marker := br.byte()
+		for marker == 0xff {
+			marker = br.byte()
+		}
+		if marker == 0xd9 || marker == 0xda {
+			break
+		}
+		size := int(br.uint16(binary.BigEndian)) - 2
+		if size < 0 {
+			break
+		}
+		if marker != 0xe1 || size < 6 {
+			br.skip(int64(size))
+			continue
+		}
+		segment := br.bytes(size)
+		if block, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+			return parseTIFF(block)
+		}
+	}
+	return mediaInfo{}, errNoMetadata
+}
+
+// readPNG looks for the eXIf chunk, which holds a TIFF block.
+func readPNG(r io.Reader) (mediaInfo, error) {
+	br := &byteReader{r: r}
+	br.skip(8)
+	for br.err == nil {
+		size := br.uint32(binary.BigEndian)
+		kind := string(br.bytes(4))
+		switch {
+		case br.err != nil, kind == "IEND":
+			return mediaInfo{}, errNoMetadata
+		case kind == "eXIf" && size <= maxExifSize:
+			return parseTIFF(br.bytes(int(size)))
+		}
+		br.skip(int64(size) + 4)
+	}
+	return mediaInfo{}, errNoMetadata
+}
+
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// parseTIFF reads the camera from IFD0 and the capture time from the EXIF
+// IFD, falling back to the modification time recorded in IFD0.
+func parseTIFF(block []byte) (mediaInfo, error) {
+	if len(block) < 8 {
+		return mediaInfo{}, errNoMetadata
+	}
+	var order binary.ByteOrder
+	switch string(block[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return mediaInfo{}, errNoMetadata
+	}
+
+	var info mediaInfo
+	ifd0 := readIFD(block, order, order.Uint32(block[4:]))
+	info.make = ifd0.ascii(tagMake)
+	info.model = ifd0.ascii(tagModel)
+	taken := ifd0.ascii(tagDateTime)
+	if offset, ok := ifd0.long(tagExifIFD); ok {
+		exif := readIFD(block, order, offset)
+		if original := exif.ascii(tagDateTimeOriginal); original != "" {
+			taken = original
+		}
+	}
+	if t, err := time.Parse(exifTimeLayout, taken); err == nil {
+		info.taken = t
+	}
+
+	if info.taken.IsZero() && info.make == "" && info.model == "" {
+		return mediaInfo{}, errNoMetadata
+	}
+	return info, nil
+}
+
+// ifd is an image file directory, with the entries by tag.
+type ifd struct {
+	block   []byte
+	order   binary.ByteOrder
+	entries map[uint16][]byte
+}
+
+// readIFD reads the 12 byte entries of the directory at offset. Out of
+// range offsets give an empty directory.
+func readIFD(block []byte, order binary.ByteOrder, offset uint32) ifd {
+	d := ifd{block: block, order: order, entries: make(map[uint16][]byte)}
+	if int64(offset)+2 > int64(len(block)) {
+		return d
+	}
+	count := int(order.Uint16(block[offset:]))
+	for i := range count {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(block) {
+			break
+		}
+		d.entries[order.Uint16(block[start:])] = block[start+2 : start+12]
+	}
+	return d
+}
+
+// ascii returns the value of an ASCII entry, which is stored in the entry
+// itself when it fits in four bytes.
+func (d ifd) ascii(tag uint16) string {
+	entry, ok := d.entries[tag]
+	if !ok || d.order.Uint16(entry) != 2 {
+		return ""
+	}
+	count := d.order.Uint32(entry[2:])
+	value := entry[6:10]
+	if count > 4 {
+		offset := d.order.Uint32(entry[6:])
+		if int64(offset)+int64(count) > int64(len(d.block)) {
+			return ""
+		}
+		value = d.block[offset : offset+count]
+	} else {
+		value = value[:count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
+
+// long returns the value of a LONG entry.
+func (d ifd) long(tag uint16) (uint32, bool) {
+	entry, ok := d.entries[tag]
+	if !ok || d.order.Uint16(entry) != 4 {
+		return 0, false
+	}
+	return d.order.Uint32(entry[6:]), true
+}
+
+// isBMFF reports whether the head looks like an ISO base media file, as MP4
+// and QuickTime files are.
+func isBMFF(head []byte) bool {
+	switch string(head[4:8]) {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip":
+		return true
+	default:
+		return false
+	}
+}
+
+// bmffEpoch is where the times in ISO base media files count from.
+var bmffEpoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// readBMFF reads the creation time from the movie header, seeking past the
+// media data rather than reading it.
+func readBMFF(r io.ReadSeeker) (mediaInfo, error) {
+	moov, err := findBox(r, "moov", -1)
+	if err != nil {
+		return mediaInfo{}, err
+	}
+	mvhd, err := findBox(r, "mvhd", moov)
+	if err != nil {
+		return mediaInfo{}, err
+	}
+
+	br := &byteReader{r: io.LimitReader(r, mvhd)}
+	version := br.byte()
+	br.skip(3)
+	var created uint64
+	if version == 1 {
+		created = br.uint64(binary.BigEndian)
+	} else {
+		created = uint64(br.uint32(binary.BigEndian))
+	}
+	if br.err != nil || created == 0 {
+		return mediaInfo{}, errNoMetadata
+	}
+	taken := bmffEpoch.Add(time.Duration(created) * time.Second)
+	return mediaInfo{taken: taken.Local()}, nil
+}
+
+// findBox seeks to the payload of the first box of the kind, among the boxes
+// in the next limit bytes (or up to the end, for a negative limit), and
+// returns the payload size.
+func findBox(r io.ReadSeeker, kind string, limit int64) (int64, error) {
+	header := make([]byte, 16)
+	for limit != 0 {
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return 0, errNoMetadata
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch size {
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return 0, errNoMetadata
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		case 0:
+			// The box runs to the end of the file.
+			if string(header[4:8]) != kind {
+				return 0, errNoMetadata
+			}
+			if limit < 0 {
+				limit = math.MaxInt64
+			}
+			return limit, nil
+		}
+		if size < headerSize {
+			return 0, errNoMetadata
+		}
+		if string(header[4:8]) == kind {
+			return size - headerSize, nil
+		}
+		if _, err := r.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		if limit > 0 {
+			limit = max(0, limit-size)
+		}
+	}
+	return 0, errNoMetadata
+}
+
+// byteReader reads binary fields, keeping the first error and returning zero
+// values after it.
+type byteReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *byteReader) bytes(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	buf := make([]byte, n)
+	_, b.err = io.ReadFull(b.r, buf)
+	return buf
+}
+
+func (b *byteReader) skip(n int64) {
+	if b.err != nil {
+		return
+	}
+	_, b.err = io.CopyN(io.Discard, b.r, n)
+}
+
+func (b *byteReader) byte() byte {
+	if buf := b.bytes(1); b.err == nil {
+		return buf[0]
+	}
+	return 0
+}
+
+func (b *byteReader) uint16(order binary.ByteOrder) uint16 {
+	if buf := b.bytes(2); b.err == nil {
+		return order.Uint16(buf)
+	}
+	return 0
+}
+
+func (b *byteReader) uint32(order binary.ByteOrder) uint32 {
+	if buf := b.bytes(4); b.err == nil {
+		return order.Uint32(buf)
+	}
+	return 0
+}
+
+func (b *byteReader) uint64(order binary.ByteOrder) uint64 {
+	if buf := b.bytes(8); b.err == nil {
+		return order.Uint64(buf)
+	}
+	return 0
+}
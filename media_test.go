@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tiffEntry is an ASCII or LONG entry for buildTIFF.
+type tiffEntry struct {
+	tag   uint16
+	ascii string
+	long  uint32
+}
+
+// buildTIFF lays out a little endian TIFF block with IFD0 at offset 8 and,
+// if exif isn't empty, the EXIF IFD right after it.
+func buildTIFF(ifd0, exif []tiffEntry) []byte {
+	le := binary.LittleEndian
+	ifdSize := func(entries []tiffEntry) int { return 2 + 12*len(entries) + 4 }
+	dataStart := 8 + ifdSize(ifd0) + 12
+	if len(exif) > 0 {
+		dataStart += ifdSize(exif)
+		ifd0 = append(ifd0, tiffEntry{tag: tagExifIFD, long: uint32(8 + ifdSize(ifd0) + 12)})
+	}
+
+	var data []byte
+	writeIFD := func(b []byte, entries []tiffEntry) []byte {
+		b = le.AppendUint16(b, uint16(len(entries)))
+		for _, e := range entries {
+			b = le.AppendUint16(b, e.tag)
+			if e.ascii == "" {
+				b = le.AppendUint16(b, 4)
+				b = le.AppendUint32(b, 1)
+				b = le.AppendUint32(b, e.long)
+				continue
+			}
+			value := e.ascii + "\x00"
+			b = le.AppendUint16(b, 2)
+			b = le.AppendUint32(b, uint32(len(value)))
+			b = le.AppendUint32(b, uint32(dataStart+len(data)))
+			data = append(data, value...)
+		}
+		return le.AppendUint32(b, 0)
+	}
+
+	block := []byte("II*\x00\x08\x00\x00\x00")
+	block = writeIFD(block, ifd0)
+	if len(exif) > 0 {
+		block = writeIFD(block, exif)
+	}
+	for len(block) < dataStart {
+		block = append(block, 0)
+	}
+	return append(block, data...)
+}
+
+func cameraTIFF() []byte {
+	return buildTIFF(
+		[]tiffEntry{
+			{tag: tagMake, ascii: "Canon"},
+			{tag: tagModel, ascii: "Canon EOS"},
+			{tag: tagDateTime, ascii: "2024:07:01 10:00:00"},
+		},
+		[]tiffEntry{{tag: tagDateTimeOriginal, ascii: "2024:06:01 15:30:12"}},
+	)
+}
+
+func buildJPEG(tiff []byte) []byte {
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x04, 0x00, 0x00}
+	b = append(b, 0xff, 0xe1)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(app1)+2))
+	b = append(b, app1...)
+	return append(b, 0xff, 0xda, 0x00, 0x02, 0xff, 0xd9)
+}
+
+func buildPNG(tiff []byte) []byte {
+	b := []byte("\x89PNG\r\n\x1a\n")
+	chunk := func(kind string, data []byte) {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		b = append(b, kind...)
+		b = append(b, data...)
+		b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+	}
+	chunk("IHDR", make([]byte, 13))
+	chunk("eXIf", tiff)
+	chunk("IEND", nil)
+	return b
+}
+
+func box(kind string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)+8))
+	return append(append(b, kind...), data...)
+}
+
+func buildMP4(created time.Time) []byte {
+	mvhd := []byte{0, 0, 0, 0}
+	mvhd = binary.BigEndian.AppendUint32(mvhd, uint32(created.Sub(bmffEpoch)/time.Second))
+	mvhd = append(mvhd, make([]byte, 92)...)
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("isom\x00\x00\x02\x00")),
+		box("mdat", make([]byte, 1024)),
+		box("moov", box("udta"), box("mvhd", mvhd)),
+	}, nil)
+}
+
+// TestReadMedia verifies the capture time and camera are read from each format.
+func TestReadMedia(t *testing.T) {
+	taken := time.Date(2024, 6, 1, 15, 30, 12, 0, time.UTC)
+	cases := map[string][]byte{
+		"photo.jpg": buildJPEG(cameraTIFF()),
+		"photo.png": buildPNG(cameraTIFF()),
+		"photo.dng": cameraTIFF(),
+	}
+	tempDir := t.TempDir()
+	for name, content := range cases {
+		path := createTempFile(t, tempDir, name, string(content))
+		info, err := readMedia(osFS{}, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if !info.taken.Equal(taken) {
+			t.Errorf("%s: expected %s, got %s", name, taken, info.taken)
+		}
+		if info.make != "Canon" || info.model != "Canon EOS" {
+			t.Errorf("%s: expected Canon EOS, got %q %q", name, info.make, info.model)
+		}
+	}
+
+	created := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := createTempFile(t, tempDir, "clip.mov", string(buildMP4(created)))
+	info, err := readMedia(osFS{}, path)
+	if err != nil {
+		t.Fatalf("clip.mov: unexpected error: %v", err)
+	}
+	if !info.taken.Equal(created) {
+		t.Errorf("clip.mov: expected %s, got %s", created, info.taken)
+	}
+
+	path = createTempFile(t, tempDir, "notes.txt", "not a photo at all")
+	if _, err := readMedia(osFS{}, path); err != errNoMetadata {
+		t.Errorf("notes.txt: expected %v, got %v", errNoMetadata, err)
+	}
+}
+
+// TestWalkerWithMediaTemplate verifies photos are named from EXIF, falling back to mtime.
+func TestWalkerWithMediaTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	photo := createTempFile(t, tempDir, "IMG_0001.jpg", string(buildJPEG(cameraTIFF())))
+	plain := createTempFile(t, tempDir, "IMG_0002.jpg", "no metadata")
+	mtime := time.Date(2020, 2, 3, 4, 5, 6, 0, time.Local)
+	if err := os.Chtimes(plain, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := parseOperations([]string{"template:{date:2006-01-02_150405}_{camera:unknown}{ext}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{options: fileOptions{path: tempDir}, chain: chain}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+
+	expected := map[string]string{
+		photo: "2024-06-01_153012_CanonEOS.jpg",
+		plain: "2020-02-03_040506_unknown.jpg",
+	}
+	for path, name := range expected {
+		if got := filepath.Base(result.pairs[path]); got != name {
+			t.Errorf("expected %q, got %q", name, got)
+		}
+	}
+}
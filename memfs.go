@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memFS is a file system kept in memory, so tests run fast and the same on
+// every platform. Paths are cleaned the way filepath does, and the roots
+// ("/" and ".") always exist. Hard links share their entry.
+type memFS struct {
+	mu      sync.Mutex
+	entries map[string]*memEntry
+	// now stamps the files written, for a deterministic modification time.
+	now time.Time
+}
+
+type memEntry struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+	// open, if set, reads the content from elsewhere each time the file is
+	// opened, as for the entries of an archive, and size is its length.
+	open func() (contentReader, error)
+	size int64
+}
+
+func (e *memEntry) length() int64 {
+	if e.open != nil {
+		return e.size
+	}
+	return int64(len(e.data))
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		entries: make(map[string]*memEntry),
+		now:     time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// WriteFile creates or replaces a file, with the directories leading to it.
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := m.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if e, ok := m.entries[name]; ok && e.mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	m.entries[name] = &memEntry{data: bytes.Clone(data), mode: perm, modTime: m.now}
+	return nil
+}
+
+// AddFile creates or replaces a file whose content is only read, by open,
+// when it is opened. Its mode may be of any type but a directory.
+func (m *memFS) AddFile(name string, size int64, mode fs.FileMode, mtime time.Time, open func() (contentReader, error)) error {
+	if err := m.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if e, ok := m.entries[name]; ok && e.mode.IsDir() {
+		return &fs.PathError{Op: "write", Path: name, Err: errIsDir}
+	}
+	m.entries[name] = &memEntry{mode: mode, modTime: mtime, open: open, size: size}
+	return nil
+}
+
+// Chtimes sets the modification time of a file, as os.Chtimes does.
+func (m *memFS) Chtimes(name string, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, e, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	if isRoot(name) {
+		return nil
+	}
+	e.modTime = mtime
+	return nil
+}
+
+// ReadFile returns the content of a file.
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.file("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.open != nil {
+		f, err := e.open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	return bytes.Clone(e.data), nil
+}
+
+var errIsDir = errors.New("is a directory")
+
+func isRoot(name string) bool {
+	return name == "." || filepath.Dir(name) == name
+}
+
+// lookup returns the entry at the cleaned name. Callers hold the lock.
+func (m *memFS) lookup(op, name string) (string, *memEntry, error) {
+	name = filepath.Clean(name)
+	if isRoot(name) {
+		return name, &memEntry{mode: fs.ModeDir | 0755}, nil
+	}
+	e, ok := m.entries[name]
+	if !ok {
+		return name, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return name, e, nil
+}
+
+// file returns the regular file at name. Callers hold the lock.
+func (m *memFS) file(op, name string) (*memEntry, error) {
+	name, e, err := m.lookup(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if e.mode.IsDir() {
+		return nil, &fs.PathError{Op: op, Path: name, Err: errIsDir}
+	}
+	return e, nil
+}
+
+// checkParent fails unless the directory name would go in exists. Callers
+// hold the lock.
+func (m *memFS) checkParent(op, name string) error {
+	_, parent, err := m.lookup(op, filepath.Dir(name))
+	if err != nil {
+		return err
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: errors.New("not a directory")}
+	}
+	return nil
+}
+
+// children returns the entries right under dir, sorted by name. Callers
+// hold the lock.
+func (m *memFS) children(dir string) []string {
+	var names []string
+	for name := range m.entries {
+		if filepath.Dir(name) == dir && name != dir {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (m *memFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := m.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = m.walk(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (m *memFS) walk(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	m.mu.Lock()
+	children := m.children(filepath.Clean(path))
+	m.mu.Unlock()
+	for _, child := range children {
+		info, err := m.Lstat(child)
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		name := filepath.Join(path, info.Name())
+		if err := m.walk(name, fs.FileInfoToDirEntry(info), fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, e, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{name: filepath.Base(name), size: e.length(), entry: *e}, nil
+}
+
+func (m *memFS) Lstat(name string) (fs.FileInfo, error) {
+	return m.Stat(name)
+}
+
+func (m *memFS) Open(name string) (readFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.file("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := memInfo{name: filepath.Base(name), size: e.length(), entry: *e}
+	if e.open != nil {
+		f, err := e.open()
+		if err != nil {
+			return nil, err
+		}
+		return &memOpened{contentReader: f, info: info}, nil
+	}
+	return &memReader{Reader: bytes.NewReader(bytes.Clone(e.data)), info: info}, nil
+}
+
+func (m *memFS) Create(name string, perm fs.FileMode) (writeFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if err := m.checkParent("open", name); err != nil {
+		return nil, err
+	}
+	if _, ok := m.entries[name]; ok || isRoot(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	e := &memEntry{mode: perm, modTime: m.now}
+	m.entries[name] = e
+	return &memWriter{fs: m, name: name, entry: e}, nil
+}
+
+func (m *memFS) Rename(oldname, newname string, overwrite bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	e, err := m.file("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if err := m.checkParent("rename", newname); err != nil {
+		return err
+	}
+	if rival, ok := m.entries[newname]; ok {
+		if !overwrite {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+		}
+		if rival.mode.IsDir() {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errIsDir}
+		}
+	}
+	delete(m.entries, oldname)
+	m.entries[newname] = e
+	return nil
+}
+
+func (m *memFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	e, err := m.file("link", oldname)
+	if err != nil {
+		return err
+	}
+	if err := m.checkParent("link", newname); err != nil {
+		return err
+	}
+	if _, ok := m.entries[newname]; ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	m.entries[newname] = e
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, e, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if e.mode.IsDir() && len(m.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	delete(m.entries, name)
+	return nil
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	var missing []string
+	for dir := name; !isRoot(dir); dir = filepath.Dir(dir) {
+		e, ok := m.entries[dir]
+		if ok && !e.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		if ok {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for _, dir := range missing {
+		m.entries[dir] = &memEntry{mode: fs.ModeDir | perm, modTime: m.now}
+	}
+	return nil
+}
+
+// memInfo describes an entry as it was when it was looked at.
+type memInfo struct {
+	name  string
+	size  int64
+	entry memEntry
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.entry.mode }
+func (i memInfo) ModTime() time.Time { return i.entry.modTime }
+func (i memInfo) IsDir() bool        { return i.entry.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memReader reads a snapshot of a file taken when it was opened.
+type memReader struct {
+	*bytes.Reader
+	info memInfo
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+// contentReader reads the content of a file kept elsewhere.
+type contentReader interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+}
+
+// memOpened reads a file whose content is kept elsewhere.
+type memOpened struct {
+	contentReader
+	info memInfo
+}
+
+func (r *memOpened) Stat() (fs.FileInfo, error) { return r.info, nil }
+
+// memWriter writes straight into its entry.
+type memWriter struct {
+	fs     *memFS
+	name   string
+	entry  *memEntry
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.entry.data = append(w.entry.data, p...)
+	return len(p), nil
+}
+
+func (w *memWriter) Name() string { return w.name }
+
+func (w *memWriter) Chmod(mode fs.FileMode) error {
+	w.fs.mu.Lock()
+	defer w.fs.mu.Unlock()
+	w.entry.mode = mode.Perm() | w.entry.mode&^fs.ModePerm
+	return nil
+}
+
+func (w *memWriter) Sync() error { return nil }
+
+func (w *memWriter) Close() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	w.closed = true
+	return nil
+}
+
+// files lists the regular files, sorted.
+func (m *memFS) files() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name, e := range m.entries {
+		if !e.mode.IsDir() {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
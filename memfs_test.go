@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestMemFSWalkDir verifies the walk is in lexical order and honours SkipDir.
+func TestMemFSWalkDir(t *testing.T) {
+	m := newMemFS()
+	for _, name := range []string{"root/b.txt", "root/a/2.txt", "root/a/1.txt", "root/skip/x.txt"} {
+		if err := m.WriteFile(filepath.FromSlash(name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var walked []string
+	err := m.WalkDir("root", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "skip" {
+			return fs.SkipDir
+		}
+		walked = append(walked, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"root", "root/a", "root/a/1.txt", "root/a/2.txt", "root/b.txt"}
+	if !slices.Equal(walked, expected) {
+		t.Errorf("expected %v, got %v", expected, walked)
+	}
+
+	err = m.WalkDir("missing", func(path string, d fs.DirEntry, err error) error { return err })
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected %v for a missing root, got %v", fs.ErrNotExist, err)
+	}
+}
+
+// TestMemFSKeepsExistingFiles verifies Create and Rename don't replace files unless asked to.
+func TestMemFSKeepsExistingFiles(t *testing.T) {
+	m := newMemFS()
+	if err := m.WriteFile("a", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("b", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Create("a", 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("create: expected %v, got %v", fs.ErrExist, err)
+	}
+	if err := m.Rename("a", "b", false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("rename: expected %v, got %v", fs.ErrExist, err)
+	}
+	if err := m.Rename("a", "b", true); err != nil {
+		t.Fatalf("rename with overwrite: %v", err)
+	}
+	if data, _ := m.ReadFile("b"); string(data) != "a" {
+		t.Errorf("expected b to hold %q, got %q", "a", data)
+	}
+	if _, err := m.Create(filepath.Join("missing", "c"), 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("create in a missing folder: expected %v, got %v", fs.ErrNotExist, err)
+	}
+}
+
+// TestActionsOnMemFS verifies a plan is built and applied without touching the disk.
+func TestActionsOnMemFS(t *testing.T) {
+	m := newMemFS()
+	files := map[string]string{"aaa_1.txt": "one", "aaa_2.txt": "two", "1.txt": "taken"}
+	for name, content := range files {
+		if err := m.WriteFile(filepath.Join("data", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config{options: fileOptions{path: "data", str: "aaa_"}, fsys: m}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	opts := actionOptions{order: result.order, verify: true, fsys: m}
+	if n, err := renameAction(context.Background(), result.pairs, opts); err != nil || n != 2 {
+		t.Fatalf("expected 2 files renamed, got %d and %v", n, err)
+	}
+
+	expected := []string{"data/1.txt", "data/1_1.txt", "data/2.txt"}
+	var got []string
+	for _, name := range m.files() {
+		got = append(got, filepath.ToSlash(name))
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	pairs := map[string]string{filepath.Join("data", "2.txt"): filepath.Join("out", "two.txt")}
+	if err := makeParent(m, filepath.Join("out", "two.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := moveAction(context.Background(), pairs, opts); err != nil || n != 1 {
+		t.Fatalf("expected 1 file moved, got %d and %v", n, err)
+	}
+	if data, err := m.ReadFile(filepath.Join("out", "two.txt")); err != nil || string(data) != "two" {
+		t.Errorf("expected the moved file to hold %q, got %q and %v", "two", data, err)
+	}
+	if _, err := m.Stat(filepath.Join("data", "2.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the source to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// operation rewrites a file name as one step of a chain.
+type operation func(name string, src *source) string
+
+// source is the file a chain is renaming. Its metadata is read when an
+// operation first asks for it.
+type source struct {
+	path string
+	fsys fileSystem
+	// digests holds the hashes the chain reads, by algorithm.
+	digests map[string]string
+
+	info    fs.FileInfo
+	meta    *mediaInfo
+	metaErr error
+	tags    *audioTags
+	tagsErr error
+	doc     *docInfo
+	docErr  error
+
+	// missing lists the metadata variables the file had no value for.
+	missing []string
+}
+
+// stat returns the info of the file, read once.
+func (s *source) stat() (fs.FileInfo, error) {
+	if s.info == nil {
+		info, err := orOS(s.fsys).Stat(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.info = info
+	}
+	return s.info, nil
+}
+
+// modTime returns the modification time of the file, or the zero time if it
+// can't be read.
+func (s *source) modTime() time.Time {
+	info, err := s.stat()
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// media returns the photo or video metadata of the file.
+func (s *source) media() (mediaInfo, error) {
+	if s.meta == nil && s.metaErr == nil {
+		meta, err := readMedia(orOS(s.fsys), s.path)
+		s.meta, s.metaErr = &meta, err
+	}
+	return *s.meta, s.metaErr
+}
+
+// audio returns the audio tags of the file.
+func (s *source) audio() (audioTags, error) {
+	if s.tags == nil && s.tagsErr == nil {
+		tags, err := readAudio(orOS(s.fsys), s.path)
+		s.tags, s.tagsErr = &tags, err
+	}
+	return *s.tags, s.tagsErr
+}
+
+// created returns when the photo or video was taken, or the document was
+// created, if the file records it.
+func (s *source) created() (time.Time, bool) {
+	if meta, err := s.media(); err == nil && !meta.taken.IsZero() {
+		return meta.taken, true
+	}
+	if doc, err := s.document(); err == nil && !doc.created.IsZero() {
+		return doc.created, true
+	}
+	return time.Time{}, false
+}
+
+// document returns the metadata of the PDF or office document.
+func (s *source) document() (docInfo, error) {
+	if s.doc == nil && s.docErr == nil {
+		doc, err := readDocument(orOS(s.fsys), s.path)
+		s.doc, s.docErr = &doc, err
+	}
+	return *s.doc, s.docErr
+}
+
+// lack records that the file had no value for a metadata variable.
+func (s *source) lack(variable string) {
+	if !slices.Contains(s.missing, variable) {
+		s.missing = append(s.missing, variable)
+	}
+}
+
+// chain is the ordered list of operations, along with what they need read
+// from each file before they run.
+type chain struct {
+	steps []step
+	// hashes are computed in a single read of each file.
+	hashes []string
+}
+
+// step is either an operation on each name, or a renumbering, which needs
+// the names of all the files at once.
+type step struct {
+	op       operation
+	renumber *renumbering
+}
+
+// candidate is a file the chain is naming.
+type candidate struct {
+	src  *source
+	name string
+}
+
+// apply runs the chain over the names of all the files, one step at a time.
+func (c chain) apply(files []*candidate) {
+	for _, s := range c.steps {
+		if s.renumber != nil {
+			s.renumber.apply(files)
+			continue
+		}
+		for _, f := range files {
+			f.name = s.op(f.name, f.src)
+		}
+	}
+}
+
+// operationsFlag collects the repeated -op flags in the order they are given.
+type operationsFlag []string
+
+func (o *operationsFlag) String() string {
+	return strings.Join(*o, ", ")
+}
+
+func (o *operationsFlag) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+func parseOperations(specs []string) (chain, error) {
+	var c chain
+	for _, spec := range specs {
+		if kind, arg, _ := strings.Cut(spec, ":"); strings.EqualFold(kind, "renumber") {
+			r, err := parseRenumber(arg)
+			if err != nil {
+				return chain{}, fmt.Errorf("%q: %w", spec, err)
+			}
+			c.steps = append(c.steps, step{renumber: r})
+			continue
+		}
+		op, hashes, err := parseOperation(spec)
+		if err != nil {
+			return chain{}, fmt.Errorf("%q: %w", spec, err)
+		}
+		c.steps = append(c.steps, step{op: op})
+		for _, h := range hashes {
+			c.hashes = addHash(c.hashes, h)
+		}
+	}
+	return c, nil
+}
+
+// parseOperation builds an operation from its "kind:argument" form:
+//
+//	remove:TEXT         remove every TEXT
+//	replace:OLD=NEW     replace every OLD with NEW
+//	regex:PATTERN=REPL  replace every match, REPL may use $1 and such
+//	case:lower|upper|title
+//	trim[:CHARS]        trim spaces, or CHARS, around the name
+//	insert:POS:TEXT     insert TEXT at rune POS; "end" or negative counts from the end
+//	sanitize:PROFILE    make the name portable for PROFILE
+//	template:TEXT       build the name from TEXT and its {variables}
+//
+// An "=" in OLD, NEW, PATTERN or REPL is written as "\=". case, trim and
+// insert leave the extension alone. The hashes the operation
+// reads from the file are returned along with it.
+func parseOperation(spec string) (operation, []string, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch strings.ToLower(kind) {
+	case "remove":
+		if arg == "" {
+			return nil, nil, fmt.Errorf("remove needs a text")
+		}
+		return func(name string, _ *source) string {
+			return strings.ReplaceAll(name, arg, "")
+		}, nil, nil
+
+	case "replace":
+		old, repl, ok := cutAssign(arg)
+		if !ok || old == "" {
+			return nil, nil, fmt.Errorf("replace needs OLD=NEW")
+		}
+		return func(name string, _ *source) string {
+			return strings.ReplaceAll(name, old, repl)
+		}, nil, nil
+
+	case "regex":
+		expr, repl, ok := cutAssign(arg)
+		if !ok || expr == "" {
+			return nil, nil, fmt.Errorf("regex needs PATTERN=REPLACEMENT")
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("compile pattern: %w", err)
+		}
+		return func(name string, _ *source) string {
+			return pattern.ReplaceAllString(name, repl)
+		}, nil, nil
+
+	case "case":
+		var fn func(string) string
+		switch strings.ToLower(arg) {
+		case "lower":
+			fn = strings.ToLower
+		case "upper":
+			fn = strings.ToUpper
+		case "title":
+			fn = titleCase
+		default:
+			return nil, nil, fmt.Errorf("unknown case %q", arg)
+		}
+		return onStem(fn), nil, nil
+
+	case "trim":
+		cutset := arg
+		if cutset == "" {
+			cutset = " \t"
+		}
+		return onStem(func(stem string) string {
+			return strings.Trim(stem, cutset)
+		}), nil, nil
+
+	case "insert":
+		pos, text, ok := strings.Cut(arg, ":")
+		if !ok || text == "" {
+			return nil, nil, fmt.Errorf("insert needs POS:TEXT")
+		}
+		var index int
+		atEnd := pos == "end"
+		if !atEnd {
+			var err error
+			index, err = strconv.Atoi(pos)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid position %q", pos)
+			}
+		}
+		return onStem(func(stem string) string {
+			runes := []rune(stem)
+			i := index
+			switch {
+			case atEnd:
+				i = len(runes)
+			case i < 0:
+				i += len(runes)
+			}
+			i = max(0, min(i, len(runes)))
+			return string(runes[:i]) + text + string(runes[i:])
+		}), nil, nil
+
+	case "sanitize":
+		profile, err := getSanitizeProfile(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if profile == "" {
+			return nil, nil, fmt.Errorf("sanitize needs a profile")
+		}
+		return func(name string, _ *source) string {
+			return sanitizePath(name, profile)
+		}, nil, nil
+
+	case "template":
+		t, err := parseTemplate(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(t.segments) == 0 {
+			return nil, nil, fmt.Errorf("template needs a text")
+		}
+		return t.execute, t.hashes, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown operation %q", kind)
+	}
+}
+
+// cutAssign splits s around the first "=" that isn't escaped as "\=", and
+// unescapes the rest in both halves.
+func cutAssign(s string) (before, after string, found bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			// Skip whatever is escaped, so "\\=" still splits.
+			i++
+		case '=':
+			unescape := strings.NewReplacer(`\=`, "=")
+			return unescape.Replace(s[:i]), unescape.Replace(s[i+1:]), true
+		}
+	}
+	return s, "", false
+}
+
+// onStem applies fn to the name without its extension.
+func onStem(fn func(string) string) operation {
+	return func(name string, _ *source) string {
+		ext := filepath.Ext(name)
+		return fn(strings.TrimSuffix(name, ext)) + ext
+	}
+}
+
+func titleCase(s string) string {
+	upper := true
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '_' || r == '-' || r == '.' {
+			upper = true
+			return r
+		}
+		if upper {
+			upper = false
+			return unicode.ToUpper(r)
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseOperation verifies each operation kind rewrites names as documented.
+func TestParseOperation(t *testing.T) {
+	cases := []struct {
+		spec, name, expected string
+	}{
+		{"remove:_draft", "essay_draft.txt", "essay.txt"},
+		{"replace:IMG=photo", "IMG_0001.jpg", "photo_0001.jpg"},
+		{`regex:(\d+)-(\d+)=$2-$1`, "01-02.txt", "02-01.txt"},
+		{`replace:a\=b=c`, "a=b.txt", "c.txt"},
+		{`regex:(\w+)\=(\w+)=$2\=$1`, "k=v.txt", "v=k.txt"},
+		{`regex:\\=/`, `a\b.txt`, "a/b.txt"},
+		{"case:lower", "Hello World.TXT", "hello world.TXT"},
+		{"case:upper", "hello.txt", "HELLO.txt"},
+		{"case:title", "the quick_fox.txt", "The Quick_Fox.txt"},
+		{"trim", "  spaced  .txt", "spaced.txt"},
+		{"trim:_", "__name__.txt", "name.txt"},
+		{"insert:0:new_", "file.txt", "new_file.txt"},
+		{"insert:end:_v2", "file.txt", "file_v2.txt"},
+		{"insert:-1:X", "file.txt", "filXe.txt"},
+		{"sanitize:windows", "a:b.txt", "a_b.txt"},
+	}
+	for _, c := range cases {
+		op, _, err := parseOperation(c.spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.spec, err)
+			continue
+		}
+		if got := op(c.name, nil); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.spec, c.expected, got)
+		}
+	}
+}
+
+// TestParseOperationInvalid verifies malformed operations are rejected.
+func TestParseOperationInvalid(t *testing.T) {
+	specs := []string{
+		"", "unknown:x", "remove:", "replace:no-separator", "regex:(=x",
+		"case:sideways", "insert:x:text", "insert:3", "sanitize:",
+	}
+	for _, spec := range specs {
+		if _, _, err := parseOperation(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// TestWalkerWithChain verifies walker composes the chain into one final name per file.
+func TestWalkerWithChain(t *testing.T) {
+	tempDir := t.TempDir()
+	file1 := createTempFile(t, tempDir, " Holiday [copy] 01.JPG", "dummy")
+
+	chain, err := parseOperations([]string{
+		"remove:[copy]", "trim", "case:lower", "regex: +=_", "insert:0:2024_",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{
+		options: fileOptions{path: tempDir},
+		chain:   chain,
+	}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+
+	expected := "2024_holiday_01.JPG"
+	if got := filepath.Base(pairs[file1]); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// TestWalkerChainOnlyMatching verifies the chain only renames the files whose names contain the string to find.
+func TestWalkerChainOnlyMatching(t *testing.T) {
+	tempDir := t.TempDir()
+	file1 := createTempFile(t, tempDir, "Report_A.txt", "dummy")
+	file2 := createTempFile(t, tempDir, "Other.txt", "dummy")
+
+	chain, err := parseOperations([]string{"case:lower"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config{
+		options: fileOptions{path: tempDir, str: "_A"},
+		chain:   chain,
+	}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if got := filepath.Base(result.pairs[file1]); got != "report.txt" {
+		t.Errorf("expected %q, got %q", "report.txt", got)
+	}
+	if dst, ok := result.pairs[file2]; ok {
+		t.Errorf("expected %s to be left alone, got %s", file2, dst)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	PATH string = "path"
+	NAME string = "name"
+	SIZE string = "size"
+)
+
+func getSortKey(key string) (string, error) {
+	switch strings.ToLower(key) {
+	case "", PATH:
+		return PATH, nil
+	case NAME, MTIME, SIZE:
+		return strings.ToLower(key), nil
+	default:
+		return "", fmt.Errorf("unknown sort key %q", key)
+	}
+}
+
+// sortCandidates orders the files the plan is built from: by path, by name in
+// natural order, by modification time or by size, oldest and smallest first.
+// Ties are broken by path, so the order is the same on every run.
+func sortCandidates(files []*candidate, key string) {
+	sizes := make(map[*candidate]int64)
+	if key == SIZE {
+		for _, f := range files {
+			if info, err := f.src.stat(); err == nil {
+				sizes[f] = info.Size()
+			}
+		}
+	}
+	slices.SortStableFunc(files, func(a, b *candidate) int {
+		var c int
+		switch key {
+		case NAME:
+			c = compareNatural(filepath.Base(a.src.path), filepath.Base(b.src.path))
+		case MTIME:
+			c = a.src.modTime().Compare(b.src.modTime())
+		case SIZE:
+			c = cmp.Compare(sizes[a], sizes[b])
+		}
+		return cmp.Or(c, strings.Compare(a.src.path, b.src.path))
+	})
+}
+
+// inOrder returns the sources of pairs in order. Sources missing from order
+// come after it, sorted by path.
+func inOrder(pairs map[string]string, order []string) []string {
+	sources := make([]string, 0, len(pairs))
+	seen := make(map[string]bool, len(order))
+	for _, src := range order {
+		if _, ok := pairs[src]; ok && !seen[src] {
+			sources = append(sources, src)
+			seen[src] = true
+		}
+	}
+	for _, src := range slices.Sorted(maps.Keys(pairs)) {
+		if !seen[src] {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
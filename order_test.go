@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestSortCandidates verifies each sort key, with ties broken by path.
+func TestSortCandidates(t *testing.T) {
+	tempDir := t.TempDir()
+	paths := []string{
+		createTempFile(t, tempDir, "b10.txt", "1"),
+		createTempFile(t, tempDir, "b2.txt", "333"),
+		createTempFile(t, tempDir, "a.txt", "22"),
+	}
+	now := time.Now()
+	for i, path := range paths {
+		mtime := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string][]string{
+		PATH:  {"a.txt", "b10.txt", "b2.txt"},
+		NAME:  {"a.txt", "b2.txt", "b10.txt"},
+		MTIME: {"a.txt", "b2.txt", "b10.txt"},
+		SIZE:  {"b10.txt", "a.txt", "b2.txt"},
+	}
+	for key, expected := range cases {
+		var files []*candidate
+		for _, path := range paths {
+			files = append(files, &candidate{src: &source{path: path}})
+		}
+		sortCandidates(files, key)
+		var got []string
+		for _, f := range files {
+			got = append(got, filepath.Base(f.src.path))
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("%s: expected %v, got %v", key, expected, got)
+		}
+	}
+}
+
+// TestWalkerSortDecidesSuffixes verifies the file sorted first keeps the name
+// and the plan lists the sources in order.
+func TestWalkerSortDecidesSuffixes(t *testing.T) {
+	tempDir := t.TempDir()
+	first := createTempFile(t, tempDir, "a_x.txt", "dummy")
+	second := createTempFile(t, tempDir, "a_y.txt", "dummy")
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(second, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]string{
+		PATH:  {first, second},
+		MTIME: {second, first},
+	}
+	for key, order := range cases {
+		cfg := collidingConfig(t, tempDir, SUFFIX)
+		cfg.options.sortBy = key
+		for range 3 {
+			result, err := walker(context.Background(), cfg, nil)
+			if err != nil {
+				t.Fatalf("%s: walker error: %v", key, err)
+			}
+			if !slices.Equal(result.order, order) {
+				t.Fatalf("%s: expected order %v, got %v", key, order, result.order)
+			}
+			if filepath.Base(result.pairs[order[0]]) != "a.txt" || filepath.Base(result.pairs[order[1]]) != "a_1.txt" {
+				t.Fatalf("%s: expected %s to keep the name, got %v", key, order[0], result.pairs)
+			}
+		}
+	}
+}
+
+// TestInOrder verifies sources left out of the order come last, by path.
+func TestInOrder(t *testing.T) {
+	pairs := map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	got := inOrder(pairs, []string{"c", "x", "a", "c"})
+	expected := []string{"c", "a", "b", "d"}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
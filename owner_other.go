@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fileOwner returns the uid and gid owning the file.
+func fileOwner(info fs.FileInfo) (uid, gid string, ok bool) {
+	return "", "", false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid owning the file.
+func fileOwner(info fs.FileInfo) (uid, gid string, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(uint64(st.Uid), 10), strconv.FormatUint(uint64(st.Gid), 10), true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// partialSuffix ends the names of copies in progress.
+const partialSuffix = ".omitter-part"
+
+// partialFile is a copy in progress. It is written next to its destination
+// under a hidden name and renamed into place once complete, so an
+// interrupted copy never passes for a finished one.
+type partialFile struct {
+	writeFile
+	fsys      fileSystem
+	dst       string
+	committed bool
+}
+
+// createPartial creates a copy in progress for dst, under a random name the
+// way os.CreateTemp picks one.
+func createPartial(fsys fileSystem, dst string) (*partialFile, error) {
+	prefix := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".")
+	for try := 0; ; try++ {
+		name := prefix + strconv.FormatUint(uint64(rand.Uint32()), 10) + partialSuffix
+		f, err := fsys.Create(name, 0600)
+		if errors.Is(err, fs.ErrExist) && try < 10000 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &partialFile{writeFile: f, fsys: fsys, dst: dst}, nil
+	}
+}
+
+// commit syncs the copy and renames it to its destination. Unless overwrite
+// is set, it fails if the destination exists instead of replacing it.
+func (p *partialFile) commit(overwrite bool) error {
+	if err := p.Sync(); err != nil {
+		return fmt.Errorf("sync destination file: %w", err)
+	}
+	if err := p.Close(); err != nil {
+		return fmt.Errorf("close destination file: %w", err)
+	}
+	if err := p.fsys.Rename(p.Name(), p.dst, overwrite); err != nil {
+		return err
+	}
+	p.committed = true
+	// Make the rename itself durable, where the file system can. The copy is
+	// in place either way.
+	if s, ok := p.fsys.(dirSyncer); ok {
+		_ = s.SyncDir(filepath.Dir(p.dst))
+	}
+	return nil
+}
+
+// discard removes the copy unless it was committed.
+func (p *partialFile) discard() {
+	if p.committed {
+		return
+	}
+	p.Close()
+	p.fsys.Remove(p.Name())
+}
+
+// isPartial reports whether name is of a copy in progress.
+func isPartial(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, partialSuffix)
+}
+
+// removePartials removes the copies left in progress under root by runs
+// that crashed or were killed, and returns how many there were.
+func removePartials(fsys fileSystem, root string) (int, error) {
+	var removed int
+	err := fsys.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && path == root:
+			return fs.SkipAll
+		case err != nil:
+			return err
+		case !d.Type().IsRegular() || !isPartial(d.Name()):
+			return nil
+		}
+		if err := fsys.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCopyFileLeavesNoPartial verifies copies land in place whole, and failed
+// ones leave nothing behind.
+func TestCopyFileLeavesNoPartial(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := createTempFile(t, srcDir, "report.txt", "new content")
+	taken := createTempFile(t, dstDir, "taken.txt", "old content")
+
+	if err := copyFile(context.Background(), src, filepath.Join(dstDir, "report.txt"), actionOptions{}); err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+	if err := copyFile(context.Background(), src, taken, actionOptions{}); err == nil {
+		t.Fatal("expected an error for an existing destination")
+	}
+
+	entries, err := os.ReadDir(dstDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if isPartial(e.Name()) {
+			t.Errorf("did not expect partial copy %s", e.Name())
+		}
+	}
+	data, err := os.ReadFile(taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old content" {
+		t.Errorf("expected the existing file to be untouched, got %q", data)
+	}
+}
+
+// TestRemovePartials verifies partial copies are cleaned up and nothing else.
+func TestRemovePartials(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "album")
+	if err := os.Mkdir(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createTempFile(t, root, ".a.txt.123"+partialSuffix, "half")
+	createTempFile(t, nested, ".b.jpg.456"+partialSuffix, "half")
+	kept := []string{
+		createTempFile(t, root, "a.txt", "whole"),
+		createTempFile(t, nested, "visible"+partialSuffix, "not ours"),
+	}
+
+	removed, err := removePartials(osFS{}, root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("expected 2 partial copies removed, got %d", removed)
+	}
+	for _, path := range kept {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to be kept: %v", path, err)
+		}
+	}
+
+	if removed, err := removePartials(osFS{}, filepath.Join(root, "missing")); err != nil || removed != 0 {
+		t.Errorf("expected a missing output to be fine, got %d, %v", removed, err)
+	}
+}
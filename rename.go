@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// makeParent creates the directories leading to dst, for names that put
+// files into new folders.
+func makeParent(fsys fileSystem, dst string) error {
+	if err := fsys.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("create parent directory: %w", err)
+	}
+	return nil
+}
+
+// renameFile renames src to dst. Unless overwrite is set, it fails if dst
+// already exists instead of replacing it, but for src itself in another
+// case.
+func renameFile(src, dst string, overwrite bool) error {
+	if overwrite || caseVariant(osFS{}, src, dst) {
+		return os.Rename(src, dst)
+	}
+	return renameNoReplace(src, dst)
+}
+
+// caseVariant reports whether the file at b is the one at a, only named in
+// another case, as case-insensitive file systems find it. Hard links to one
+// file aren't variants, as their names differ by more than case.
+func caseVariant(fsys fileSystem, a, b string) bool {
+	if a == b || !strings.EqualFold(a, b) {
+		return false
+	}
+	aInfo, err := fsys.Lstat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := fsys.Lstat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+// renameByLink is the fallback for renameNoReplace where the platform or the
+// file system has no such rename: linking fails when dst exists, so it is
+// safe from races. File systems without hard links get a plain check before
+// renaming.
+func renameByLink(src, dst string) error {
+	err := os.Link(src, dst)
+	switch {
+	case err == nil:
+		return os.Remove(src)
+	case errors.Is(err, fs.ErrExist):
+		return err
+	}
+
+	if _, err := os.Lstat(dst); err == nil {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("get file(%q) info: %w", dst, err)
+	}
+	return os.Rename(src, dst)
+}
+
+// move is a step of applying renamed or moved pairs in order.
+type move struct {
+	src, dst string
+	// origin is the source of the pair the step belongs to.
+	origin string
+	// temp is set for the first half of a move through a temporary name.
+	temp bool
+}
+
+// chainOrder orders pairs so that each file moves out of the way before
+// another one takes its path, as when a sequence shifts down by one, and
+// otherwise keeps to order. Cycles, like two files swapping names, go
+// through a temporary name. Pairs whose destination is hard-linked come
+// last, so the files they link to exist by then.
+func chainOrder(pairs, links map[string]string, order []string) []move {
+	const (
+		visiting = iota + 1
+		done
+	)
+	state := make(map[string]int)
+	// after holds the moves waiting for a file to leave its path.
+	after := make(map[string][]move)
+	var moves, linked []move
+
+	var visit func(src string)
+	visit = func(src string) {
+		state[src] = visiting
+		dst := pairs[src]
+		if dst == src {
+			// A file planned onto its own path has nowhere to go, and
+			// parking it as a cycle of one would leave it parked.
+			state[src] = done
+			return
+		}
+		if _, ok := pairs[dst]; ok {
+			switch state[dst] {
+			case 0:
+				visit(dst)
+			case visiting:
+				temp := parkedName(src)
+				moves = append(moves, move{src: src, dst: temp, origin: src, temp: true})
+				after[dst] = append(after[dst], move{src: temp, dst: dst, origin: src})
+				state[src] = done
+				return
+			}
+		}
+		moves = append(moves, move{src: src, dst: dst, origin: src})
+		moves = append(moves, after[src]...)
+		state[src] = done
+	}
+	for _, src := range inOrder(pairs, order) {
+		switch {
+		case state[src] != 0:
+		case links[pairs[src]] != "":
+			linked = append(linked, move{src: src, dst: pairs[src], origin: src})
+		default:
+			visit(src)
+		}
+	}
+	return append(moves, linked...)
+}
+
+// midCycle reports whether a file is parked under a temporary name before
+// moves[i], so stopping there would strand it.
+func midCycle(moves []move, i int) bool {
+	parked := 0
+	for _, m := range moves[:i] {
+		switch {
+		case m.temp:
+			parked++
+		case m.src != m.origin:
+			parked--
+		}
+	}
+	return parked > 0
+}
+
+// parkedSuffix ends the names files are parked under while a cycle of
+// renames goes round.
+const parkedSuffix = ".omitter"
+
+// parkedName is where the file at src is parked mid-cycle.
+func parkedName(src string) string {
+	return filepath.Join(filepath.Dir(src), "."+filepath.Base(src)+parkedSuffix)
+}
+
+// isParked reports whether name is of a file parked mid-cycle.
+func isParked(name string) bool {
+	return len(name) > len("."+parkedSuffix) && strings.HasPrefix(name, ".") && strings.HasSuffix(name, parkedSuffix)
+}
+
+// restoreParked puts the files a killed run left parked under root back
+// under their names, and returns how many it did and how many it left as
+// their names were taken meanwhile, which -resume finishes.
+func restoreParked(fsys fileSystem, root string) (int, int, error) {
+	var restored, left int
+	err := fsys.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && path == root:
+			return fs.SkipAll
+		case err != nil:
+			return err
+		case d.IsDir() || !isParked(d.Name()):
+			return nil
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(d.Name(), "."), parkedSuffix)
+		err = fsys.Rename(path, filepath.Join(filepath.Dir(path), name), false)
+		switch {
+		case errors.Is(err, fs.ErrExist):
+			left++
+		case err != nil:
+			return err
+		default:
+			restored++
+		}
+		return nil
+	})
+	return restored, left, err
+}
+
+// unpark finishes the pairs whose source a killed run left parked: the file
+// goes back to its source if that is free, for the pair to be applied anew,
+// or on to its destination if the cycle already took the source. It drops
+// the pairs it finished.
+func unpark(fsys fileSystem, pairs map[string]string) error {
+	for src, dst := range pairs {
+		parked := parkedName(src)
+		if _, err := fsys.Lstat(parked); err != nil {
+			continue
+		}
+		err := fsys.Rename(parked, src, false)
+		if errors.Is(err, fs.ErrExist) {
+			if err = fsys.Rename(parked, dst, false); err == nil {
+				delete(pairs, src)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%q: %w", parked, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// renameNoReplace renames src to dst, failing if dst exists.
+func renameNoReplace(src, dst string) error {
+	err := unix.RenamexNp(src, dst, unix.RENAME_EXCL)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.ENOTSUP), errors.Is(err, unix.EINVAL):
+		// Some file systems don't support the flag.
+		return renameByLink(src, dst)
+	default:
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// renameNoReplace renames src to dst, failing if dst exists.
+func renameNoReplace(src, dst string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, src, unix.AT_FDCWD, dst, unix.RENAME_NOREPLACE)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.EINVAL), errors.Is(err, unix.ENOSYS):
+		// Older kernels, and some file systems, don't support the flag.
+		return renameByLink(src, dst)
+	default:
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+}
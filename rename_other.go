@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// renameNoReplace renames src to dst, failing if dst exists.
+func renameNoReplace(src, dst string) error {
+	return renameByLink(src, dst)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestActionsKeepExistingFiles verifies no action replaces an existing destination without consent.
+func TestActionsKeepExistingFiles(t *testing.T) {
+	actions := map[string]func(context.Context, map[string]string, actionOptions) (uint, error){
+		RENAME: renameAction,
+		COPY:   copyAction,
+		MOVE:   moveAction,
+	}
+	for name, action := range actions {
+		tempDir := t.TempDir()
+		src := createTempFile(t, tempDir, "new.txt", "new")
+		dst := createTempFile(t, tempDir, "existing.txt", "existing")
+
+		n, err := action(context.Background(), map[string]string{src: dst}, actionOptions{})
+		if !errors.Is(err, fs.ErrExist) {
+			t.Errorf("%s: expected an error for the existing file, got %v", name, err)
+		}
+		if n != 0 {
+			t.Errorf("%s: expected 0 files, got %d", name, n)
+		}
+		b, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("%s: failed to read file: %v", name, err)
+		}
+		if string(b) != "existing" {
+			t.Errorf("%s: expected existing file to be kept, got %q", name, b)
+		}
+		if _, err := os.Stat(src); err != nil {
+			t.Errorf("%s: expected source file to be kept, error: %v", name, err)
+		}
+	}
+}
+
+// TestActionsOverwrite verifies every action replaces the destination when allowed.
+func TestActionsOverwrite(t *testing.T) {
+	actions := map[string]func(context.Context, map[string]string, actionOptions) (uint, error){
+		RENAME: renameAction,
+		COPY:   copyAction,
+		MOVE:   moveAction,
+	}
+	for name, action := range actions {
+		tempDir := t.TempDir()
+		src := createTempFile(t, tempDir, "new.txt", "new")
+		dst := createTempFile(t, tempDir, "existing.txt", "existing")
+
+		if _, err := action(context.Background(), map[string]string{src: dst}, actionOptions{overwrite: true}); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		b, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("%s: failed to read file: %v", name, err)
+		}
+		if string(b) != "new" {
+			t.Errorf("%s: expected file to be replaced, got %q", name, b)
+		}
+	}
+}
+
+// TestRenameByLink verifies the fallback rename refuses existing destinations.
+func TestRenameByLink(t *testing.T) {
+	tempDir := t.TempDir()
+	src := createTempFile(t, tempDir, "a.txt", "a")
+	taken := createTempFile(t, tempDir, "b.txt", "b")
+
+	if err := renameByLink(src, taken); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected an error for the existing file, got %v", err)
+	}
+
+	dst := filepath.Join(tempDir, "c.txt")
+	if err := renameByLink(src, dst); err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("expected original file %s to be removed", src)
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("expected new file %s to exist, error: %v", dst, err)
+	}
+}
+
+// TestRestoreParked verifies parked files go back under their names where those are free, and are left alone otherwise.
+func TestRestoreParked(t *testing.T) {
+	tempDir := t.TempDir()
+	createTempFile(t, tempDir, ".a.txt"+parkedSuffix, "a")
+	parked := createTempFile(t, tempDir, ".b.txt"+parkedSuffix, "b")
+	createTempFile(t, tempDir, "b.txt", "taken")
+
+	result, err := walker(context.Background(), config{options: fileOptions{path: tempDir, str: "."}}, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if _, ok := result.pairs[parked]; ok {
+		t.Errorf("expected the walker to leave parked files alone, got %v", result.pairs)
+	}
+
+	restored, left, err := restoreParked(osFS{}, tempDir)
+	if err != nil || restored != 1 || left != 1 {
+		t.Fatalf("expected 1 file restored and 1 left, got %d, %d and %v", restored, left, err)
+	}
+	for name, content := range map[string]string{"a.txt": "a", "b.txt": "taken", ".b.txt" + parkedSuffix: "b"} {
+		if data, err := os.ReadFile(filepath.Join(tempDir, name)); err != nil || string(data) != content {
+			t.Errorf("%s: expected %q, got %q and %v", name, content, data, err)
+		}
+	}
+}
+
+// TestUnpark verifies a file parked by a killed swap goes back to its source while that is free, and on to its destination once the other file took it.
+func TestUnpark(t *testing.T) {
+	tempDir := t.TempDir()
+	a, b := filepath.Join(tempDir, "a"), filepath.Join(tempDir, "b")
+
+	// Killed right after parking a: both pairs are left.
+	createTempFile(t, tempDir, ".a"+parkedSuffix, "a")
+	createTempFile(t, tempDir, "b", "b")
+	pairs := map[string]string{a: b, b: a}
+	if err := unpark(osFS{}, pairs); err != nil || len(pairs) != 2 {
+		t.Fatalf("expected both pairs to stay, got %v and %v", pairs, err)
+	}
+	if data, err := os.ReadFile(a); err != nil || string(data) != "a" {
+		t.Errorf("expected a back in place, got %q and %v", data, err)
+	}
+
+	// Killed once b took the place of a: only a is left.
+	if err := os.Rename(a, parkedName(a)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(b, a); err != nil {
+		t.Fatal(err)
+	}
+	pairs = map[string]string{a: b}
+	if err := unpark(osFS{}, pairs); err != nil || len(pairs) != 0 {
+		t.Fatalf("expected the pair to be done, got %v and %v", pairs, err)
+	}
+	for path, content := range map[string]string{a: "b", b: "a"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("%s: expected %q, got %q and %v", path, content, data, err)
+		}
+	}
+}
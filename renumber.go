@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// renumbering gives the files of a sequence, like frame_1.png to
+// frame_1000.png, contiguous numbers in natural order. A sequence is the
+// files of one folder whose names only differ by their last run of digits.
+type renumbering struct {
+	start int
+	step  int
+	// width pads the numbers with zeros. When zero, it is the width of the
+	// largest number of the sequence.
+	width int
+}
+
+// parseRenumber parses the START:STEP:WIDTH argument of renumber, each part
+// of which may be left out.
+func parseRenumber(arg string) (*renumbering, error) {
+	r := &renumbering{start: 1, step: 1}
+	if arg == "" {
+		return r, nil
+	}
+	parts := strings.Split(arg, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("renumber takes at most START:STEP:WIDTH")
+	}
+	fields := []*int{&r.start, &r.step, &r.width}
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("renumber: %q is not a non-negative number", part)
+		}
+		*fields[i] = n
+	}
+	if r.step == 0 {
+		return nil, fmt.Errorf("renumber: step can't be zero")
+	}
+	return r, nil
+}
+
+// apply renumbers the sequences among files. Names without digits are left
+// alone.
+func (r *renumbering) apply(files []*candidate) {
+	sequences := make(map[string][]*candidate)
+	var keys []string
+	for _, f := range files {
+		start, end := lastNumber(f.name)
+		if start < 0 {
+			continue
+		}
+		key := filepath.Join(filepath.Dir(f.src.path), f.name[:start]+"\x00"+f.name[end:])
+		if _, ok := sequences[key]; !ok {
+			keys = append(keys, key)
+		}
+		sequences[key] = append(sequences[key], f)
+	}
+
+	for _, key := range keys {
+		sequence := sequences[key]
+		slices.SortStableFunc(sequence, func(a, b *candidate) int {
+			return cmp.Or(
+				compareNumbers(a.number(), b.number()),
+				compareNatural(a.name, b.name),
+			)
+		})
+		width := r.width
+		if width == 0 {
+			width = len(strconv.Itoa(r.start + (len(sequence)-1)*r.step))
+		}
+		for i, f := range sequence {
+			start, end := lastNumber(f.name)
+			number := fmt.Sprintf("%0*d", width, r.start+i*r.step)
+			f.name = f.name[:start] + number + f.name[end:]
+		}
+	}
+}
+
+// number returns the last run of digits in the name.
+func (c *candidate) number() string {
+	start, end := lastNumber(c.name)
+	return c.name[start:end]
+}
+
+// lastNumber returns the bounds of the last run of digits in the stem of
+// name, or -1 and -1 if it has none.
+func lastNumber(name string) (int, int) {
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	end := strings.LastIndexFunc(stem, isDigitRune) + 1
+	if end == 0 {
+		return -1, -1
+	}
+	start := strings.LastIndexFunc(stem[:end], func(r rune) bool { return !isDigitRune(r) }) + 1
+	return start, end
+}
+
+func isDigitRune(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// compareNumbers compares runs of digits by their value, however long.
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	return cmp.Or(cmp.Compare(len(a), len(b)), strings.Compare(a, b))
+}
+
+// compareNatural compares names the way people sort them, with the runs of
+// digits compared by value, so frame_2 comes before frame_10.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		aDigits, bDigits := isDigitRune(rune(a[0])), isDigitRune(rune(b[0]))
+		if !aDigits || !bDigits {
+			if a[0] != b[0] {
+				return cmp.Compare(a[0], b[0])
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		aEnd := len(a) - len(strings.TrimLeftFunc(a, isDigitRune))
+		bEnd := len(b) - len(strings.TrimLeftFunc(b, isDigitRune))
+		if c := compareNumbers(a[:aEnd], b[:bEnd]); c != 0 {
+			return c
+		}
+		a, b = a[aEnd:], b[bEnd:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestParseRenumber verifies the start, step and width can each be left out.
+func TestParseRenumber(t *testing.T) {
+	cases := map[string]renumbering{
+		"":      {start: 1, step: 1},
+		"0":     {start: 0, step: 1},
+		"10:10": {start: 10, step: 10},
+		"::4":   {start: 1, step: 1, width: 4},
+		"5::3":  {start: 5, step: 1, width: 3},
+	}
+	for arg, expected := range cases {
+		r, err := parseRenumber(arg)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", arg, err)
+			continue
+		}
+		if *r != expected {
+			t.Errorf("%q: expected %+v, got %+v", arg, expected, *r)
+		}
+	}
+	for _, arg := range []string{"x", "-1", "1:0", "1:1:1:1"} {
+		if _, err := parseRenumber(arg); err == nil {
+			t.Errorf("%q: expected an error", arg)
+		}
+	}
+}
+
+// TestCompareNatural verifies runs of digits are compared by value.
+func TestCompareNatural(t *testing.T) {
+	ordered := []string{"frame_2.png", "frame_10.png", "frame_010b.png", "frame_100.png", "frame_a.png"}
+	for i := 1; i < len(ordered); i++ {
+		if compareNatural(ordered[i-1], ordered[i]) >= 0 {
+			t.Errorf("expected %q before %q", ordered[i-1], ordered[i])
+		}
+	}
+}
+
+// renumberDir renumbers the files of dir in place and returns the content
+// of each file by name afterwards.
+func renumberDir(t *testing.T, dir string, specs ...string) map[string]string {
+	t.Helper()
+	chain, err := parseOperations(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := walker(context.Background(), config{options: fileOptions{path: dir}, chain: chain}, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	if _, err := renameAction(context.Background(), result.pairs, actionOptions{}); err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := make(map[string]string)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[e.Name()] = string(data)
+	}
+	return contents
+}
+
+// TestRenumberClosesGaps verifies a sequence shifts down into its gaps without collisions.
+func TestRenumberClosesGaps(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, n := range []string{"2", "3", "5", "10"} {
+		createTempFile(t, tempDir, "frame_"+n+".png", n)
+	}
+	createTempFile(t, tempDir, "notes.txt", "notes")
+
+	got := renumberDir(t, tempDir, "renumber")
+	expected := map[string]string{
+		"frame_1.png": "2", "frame_2.png": "3", "frame_3.png": "5", "frame_4.png": "10",
+		"notes.txt": "notes",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for name, content := range expected {
+		if got[name] != content {
+			t.Errorf("expected %s to hold %q, got %q", name, content, got[name])
+		}
+	}
+}
+
+// TestRenumberShiftsUp verifies a sequence can move up onto its own numbers, padded.
+func TestRenumberShiftsUp(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, n := range []string{"1", "2", "3"} {
+		createTempFile(t, tempDir, "shot"+n+".jpg", n)
+	}
+
+	got := renumberDir(t, tempDir, "renumber:2::3")
+	expected := map[string]string{"shot002.jpg": "1", "shot003.jpg": "2", "shot004.jpg": "3"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for name, content := range expected {
+		if got[name] != content {
+			t.Errorf("expected %s to hold %q, got %q", name, content, got[name])
+		}
+	}
+}
+
+// TestChainOrderSwap verifies files swapping names go through a temporary name.
+func TestChainOrderSwap(t *testing.T) {
+	tempDir := t.TempDir()
+	a := createTempFile(t, tempDir, "a.txt", "a")
+	b := createTempFile(t, tempDir, "b.txt", "b")
+
+	n, err := renameAction(context.Background(), map[string]string{a: b, b: a}, actionOptions{})
+	if err != nil {
+		t.Fatalf("rename error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 files renamed, got %d", n)
+	}
+	for path, content := range map[string]string{a: "b", b: "a"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("expected %s to hold %q, got %q", path, content, data)
+		}
+	}
+}
+
+// TestChainOrderSelfPair verifies a file planned onto its own path is left where it is, not parked.
+func TestChainOrderSelfPair(t *testing.T) {
+	moves := chainOrder(map[string]string{"a": "a", "b": "c"}, nil, []string{"a", "b"})
+	if len(moves) != 1 || moves[0] != (move{src: "b", dst: "c", origin: "b"}) {
+		t.Errorf("expected only b to move, got %+v", moves)
+	}
+}
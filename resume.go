@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runState is what an interrupted or failed run leaves for -resume: the
+// pairs it didn't get to, and how to apply them.
+type runState struct {
+	Action string `json:"action"`
+	// Roots are the folders or URLs the run was asked for.
+	Roots     []string          `json:"roots,omitempty"`
+	Pairs     map[string]string `json:"pairs"`
+	Links     map[string]string `json:"links,omitempty"`
+	Order     []string          `json:"order,omitempty"`
+	Overwrite bool              `json:"overwrite,omitempty"`
+	Verify    bool              `json:"verify,omitempty"`
+	Retries   int               `json:"retries,omitempty"`
+	Reflink   string            `json:"reflink,omitempty"`
+	// S3Endpoint is where the objects of a run on a bucket are, and
+	// SFTPHost the user@host:port of a run on a remote host.
+	S3Endpoint string `json:"s3-endpoint,omitempty"`
+	SFTPHost   string `json:"sftp-host,omitempty"`
+	// Git is the root of the working tree the run stages its renames in.
+	Git string `json:"git,omitempty"`
+	// Rewrites are the text files whose references are updated once the
+	// pairs are done.
+	Rewrites []pendingRewrite `json:"rewrites,omitempty"`
+}
+
+// newRunState keeps the pairs of the run that aren't done yet. The paths of a
+// run on the local disk are made absolute, so it can be resumed from any
+// directory.
+func newRunState(action string, pairs map[string]string, opts actionOptions) runState {
+	state := runState{
+		Action:    action,
+		Roots:     opts.roots,
+		Pairs:     make(map[string]string),
+		Links:     make(map[string]string),
+		Overwrite: opts.overwrite,
+		Verify:    opts.verify,
+		Retries:   opts.retries,
+		Reflink:   opts.reflink,
+	}
+	switch fsys := opts.fsys.(type) {
+	case *s3FS:
+		state.S3Endpoint = fsys.endpoint
+	case *sftpFS:
+		state.SFTPHost = fsys.host
+	}
+	if opts.git != nil {
+		state.Git = opts.git.root
+	}
+	for src, dst := range pairs {
+		if opts.done[src] {
+			continue
+		}
+		state.Pairs[absPath(opts.fsys, src)] = absPath(opts.fsys, dst)
+		if target, ok := opts.links[dst]; ok {
+			state.Links[absPath(opts.fsys, dst)] = absPath(opts.fsys, target)
+		}
+	}
+	for _, src := range opts.order {
+		if src = absPath(opts.fsys, src); state.Pairs[src] != "" {
+			state.Order = append(state.Order, src)
+		}
+	}
+	for _, r := range opts.rewrites {
+		r.Path = absPath(opts.fsys, r.Path)
+		state.Rewrites = append(state.Rewrites, r)
+	}
+	return state
+}
+
+// absPath returns path absolute if it is on the local disk, and as it is
+// otherwise.
+func absPath(fsys fileSystem, path string) string {
+	if fsys != nil {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+func (s runState) options() actionOptions {
+	return actionOptions{
+		overwrite: s.Overwrite,
+		roots:     s.Roots,
+		links:     s.Links,
+		order:     s.Order,
+		verify:    s.Verify,
+		retries:   s.Retries,
+		reflink:   s.Reflink,
+		rewrites:  s.Rewrites,
+	}
+}
+
+// stateDir is where the states of unfinished runs are kept.
+func stateDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "omitter")
+}
+
+// statePath is where the state of an unfinished run is kept. Each action
+// over a set of roots has its own, so runs over other folders leave it be.
+func statePath(action string, roots []string) string {
+	h := sha256.New()
+	fmt.Fprintln(h, action)
+	for _, root := range roots {
+		fmt.Fprintln(h, root)
+	}
+	return filepath.Join(stateDir(), fmt.Sprintf("resume-%x.json", h.Sum(nil)[:8]))
+}
+
+// stateRoots returns the paths a run is asked for as they name its state:
+// absolute for local folders, and as given for buckets and remote hosts.
+func stateRoots(paths []string) []string {
+	roots := make([]string, len(paths))
+	for i, path := range paths {
+		roots[i] = path
+		if isS3Path(path) || isSFTPPath(path) {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			roots[i] = abs
+		}
+	}
+	return roots
+}
+
+// findState returns the path of the state to resume: the one of the run over
+// roots, or the only one there is if no roots are given.
+func findState(roots []string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(stateDir(), "resume-*.json"))
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	var runs []string
+	for _, path := range paths {
+		state, err := loadState(path)
+		if err != nil {
+			continue
+		}
+		if len(roots) > 0 && !slices.Equal(state.Roots, roots) {
+			continue
+		}
+		found = append(found, path)
+		runs = append(runs, fmt.Sprintf("  %s of %d file(s) in %s", state.Action, len(state.Pairs), strings.Join(state.Roots, ", ")))
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no unfinished run to resume")
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%d unfinished runs, pick one with -p or -c:\n%s", len(found), strings.Join(runs, "\n"))
+	}
+}
+
+// saveState replaces the state at path whole, so a run killed while saving
+// leaves the one before.
+func saveState(path string, state runState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := createPartial(osFS{}, path)
+	if err != nil {
+		return err
+	}
+	defer out.discard()
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+	return out.commit(true)
+}
+
+// removeState removes the state at path along with its progress.
+func removeState(path string) {
+	_ = os.Remove(path)
+	_ = os.Remove(progressPath(path))
+}
+
+// loadState reads the state at path, leaving out the pairs its progress
+// says are done.
+func loadState(path string) (runState, error) {
+	var state runState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, fmt.Errorf("no unfinished run to resume")
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	f, err := os.Open(progressPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var src string
+		// A line cut short by a kill is of a file that wasn't logged.
+		if err := json.Unmarshal(scanner.Bytes(), &src); err != nil {
+			break
+		}
+		delete(state.Pairs, src)
+	}
+	if err := scanner.Err(); err != nil {
+		return state, fmt.Errorf("read %s: %w", progressPath(path), err)
+	}
+	state.Order = slices.DeleteFunc(state.Order, func(src string) bool {
+		return state.Pairs[src] == ""
+	})
+	return state, nil
+}
+
+// progressPath is where the sources done since the state at path was saved
+// are logged.
+func progressPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".log"
+}
+
+// progressLog logs the sources a run is done with as it goes, one JSON
+// string a line, so even a run that is killed can be resumed.
+type progressLog struct {
+	f *os.File
+	// err is the first write that failed. The run goes on, but its log
+	// can't be trusted anymore.
+	err error
+}
+
+func createProgress(path string) (*progressLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &progressLog{f: f}, nil
+}
+
+// add logs that the run is done with src.
+func (l *progressLog) add(src string) {
+	if l.err != nil {
+		return
+	}
+	line, err := json.Marshal(src)
+	if err != nil {
+		l.err = err
+		return
+	}
+	_, l.err = l.f.Write(append(line, '\n'))
+}
+
+func (l *progressLog) close() error {
+	return cmp.Or(l.err, l.f.Close())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestNewRunStateSkipsDone verifies only the pairs left to do are kept, in order, with absolute paths.
+func TestNewRunStateSkipsDone(t *testing.T) {
+	pairs := map[string]string{"a": "out/a", "b": "out/b", "c": "out/c"}
+	opts := actionOptions{
+		links:   map[string]string{"out/c": "out/b"},
+		order:   []string{"a", "b", "c"},
+		reflink: NEVER,
+		done:    map[string]bool{"a": true},
+	}
+	state := newRunState(COPY, pairs, opts)
+
+	abs := func(path string) string { return absPath(nil, filepath.FromSlash(path)) }
+	expected := map[string]string{abs("b"): abs("out/b"), abs("c"): abs("out/c")}
+	if !reflect.DeepEqual(state.Pairs, expected) {
+		t.Errorf("expected %v, got %v", expected, state.Pairs)
+	}
+	if !reflect.DeepEqual(state.Order, []string{abs("b"), abs("c")}) {
+		t.Errorf("expected order [b c], got %v", state.Order)
+	}
+	if state.Links[abs("out/c")] != abs("out/b") {
+		t.Errorf("expected the link to be kept, got %v", state.Links)
+	}
+	if got := state.options(); got.reflink != NEVER {
+		t.Errorf("expected reflink %q, got %q", NEVER, got.reflink)
+	}
+
+	opts.fsys = newMemFS()
+	if state := newRunState(COPY, pairs, opts); state.Pairs["b"] != "out/b" {
+		t.Errorf("expected paths off the local disk to be kept as they are, got %v", state.Pairs)
+	}
+}
+
+// TestLoadStateWithProgress verifies the files logged as done are left out of a saved state.
+func TestLoadStateWithProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "omitter", "resume-test.json")
+	state := runState{
+		Action: RENAME,
+		Pairs:  map[string]string{"/d/a": "/d/1", "/d/b": "/d/2", "/d/c": "/d/3"},
+		Order:  []string{"/d/a", "/d/b", "/d/c"},
+	}
+	if err := saveState(path, state); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	progress, err := createProgress(progressPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress.add("/d/b")
+	if err := progress.close(); err != nil {
+		t.Fatal(err)
+	}
+	// A kill part way through a line leaves it cut short.
+	f, err := os.OpenFile(progressPath(path), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`"/d/`)
+	f.Close()
+
+	got, err := loadState(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	expected := map[string]string{"/d/a": "/d/1", "/d/c": "/d/3"}
+	if !reflect.DeepEqual(got.Pairs, expected) || !reflect.DeepEqual(got.Order, []string{"/d/a", "/d/c"}) {
+		t.Errorf("expected %v in order [/d/a /d/c], got %v in order %v", expected, got.Pairs, got.Order)
+	}
+}
+
+// TestApplyLeavesState verifies a failed run saves what it didn't do, and resuming it removes the state.
+func TestApplyLeavesState(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	tempDir := t.TempDir()
+	a := createTempFile(t, tempDir, "a", "a")
+	missing := filepath.Join(tempDir, "missing")
+	roots := []string{tempDir}
+
+	pairs := map[string]string{a: filepath.Join(tempDir, "1"), missing: filepath.Join(tempDir, "2")}
+	opts := actionOptions{order: []string{a, missing}, roots: roots}
+	if code := apply(RENAME, pairs, opts, false); code != 2 {
+		t.Fatalf("expected the run to fail, got exit code %d", code)
+	}
+	state, err := loadState(statePath(RENAME, roots))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(state.Pairs) != 1 || state.Pairs[missing] == "" {
+		t.Errorf("expected only %s to be left, got %v", missing, state.Pairs)
+	}
+	if _, err := os.Stat(progressPath(statePath(RENAME, roots))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the progress to be folded into the state, got %v", err)
+	}
+
+	createTempFile(t, tempDir, "missing", "found")
+	if code := apply(RENAME, state.Pairs, state.options(), false); code != 0 {
+		t.Fatalf("expected the resumed run to finish, got exit code %d", code)
+	}
+	if _, err := os.Stat(statePath(RENAME, roots)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the state to be removed, got %v", err)
+	}
+}
+
+// TestApplyRewritesAfterResume verifies references are only updated once the files they name are renamed, by the resumed run if the first one stops.
+func TestApplyRewritesAfterResume(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	tempDir := t.TempDir()
+	createTempFile(t, tempDir, "aaa_a.png", "a")
+	index := createTempFile(t, tempDir, "index.md", "![](aaa_a.png) ![](aaa_b.png)\n")
+	roots := []string{tempDir}
+
+	pairs := map[string]string{
+		filepath.Join(tempDir, "aaa_a.png"): filepath.Join(tempDir, "a.png"),
+		filepath.Join(tempDir, "aaa_b.png"): filepath.Join(tempDir, "b.png"),
+	}
+	rewrites, err := planRewrites(context.Background(), orOS(nil), roots, []string{"*.md"}, pairs)
+	if err != nil || len(rewrites) != 1 {
+		t.Fatalf("expected 1 rewrite, got %d and %v", len(rewrites), err)
+	}
+	opts := actionOptions{roots: roots, rewrites: []pendingRewrite{rewrites[0].pending()}}
+	if code := apply(RENAME, pairs, opts, false); code != 2 {
+		t.Fatalf("expected the run to fail, got exit code %d", code)
+	}
+	if data, _ := os.ReadFile(index); string(data) != "![](aaa_a.png) ![](aaa_b.png)\n" {
+		t.Errorf("expected the references to wait for the renames, got %q", data)
+	}
+	state, err := loadState(statePath(RENAME, roots))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(state.Rewrites) != 1 || state.Rewrites[0].Path != index {
+		t.Fatalf("expected the rewrite of %s to be left, got %+v", index, state.Rewrites)
+	}
+
+	createTempFile(t, tempDir, "aaa_b.png", "b")
+	if code := apply(RENAME, state.Pairs, state.options(), false); code != 0 {
+		t.Fatalf("expected the resumed run to finish, got exit code %d", code)
+	}
+	if data, _ := os.ReadFile(index); string(data) != "![](a.png) ![](b.png)\n" {
+		t.Errorf("expected the references to be updated, got %q", data)
+	}
+}
+
+// TestSaveAndLoadState verifies the state survives a round trip, and a missing one is reported.
+func TestSaveAndLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "omitter", "resume.json")
+	if _, err := loadState(path); err == nil {
+		t.Error("expected an error for a missing state")
+	}
+
+	state := runState{
+		Action:  MOVE,
+		Pairs:   map[string]string{"a": "b"},
+		Order:   []string{"a"},
+		Verify:  true,
+		Retries: 2,
+	}
+	if err := saveState(path, state); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	got, err := loadState(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(got, state) {
+		t.Errorf("expected %+v, got %+v", state, got)
+	}
+}
+
+// TestFindState verifies each run over other folders keeps its own state to resume.
+func TestFindState(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if _, err := findState(nil); err == nil {
+		t.Error("expected an error with no state saved")
+	}
+
+	photos, music := []string{"/data/photos"}, []string{"/data/music"}
+	for _, roots := range [][]string{photos, music} {
+		state := runState{Action: RENAME, Roots: roots, Pairs: map[string]string{"a": "b"}}
+		if err := saveState(statePath(RENAME, roots), state); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+	if statePath(RENAME, photos) == statePath(RENAME, music) || statePath(RENAME, photos) == statePath(MOVE, photos) {
+		t.Error("expected a state for each action and roots")
+	}
+	if path, err := findState(music); err != nil || path != statePath(RENAME, music) {
+		t.Errorf("expected %s, got %s and %v", statePath(RENAME, music), path, err)
+	}
+	if _, err := findState(nil); err == nil {
+		t.Error("expected an error with two runs to pick from")
+	}
+}
+
+// TestActionStopsWhenCancelled verifies a cancelled run stops between files and records what it did.
+func TestActionStopsWhenCancelled(t *testing.T) {
+	tempDir := t.TempDir()
+	src := createTempFile(t, tempDir, "a.txt", "a")
+	dst := filepath.Join(tempDir, "b.txt")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := actionOptions{done: make(map[string]bool)}
+	n, err := copyAction(ctx, map[string]string{src: dst}, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if n != 0 || len(opts.done) != 0 {
+		t.Errorf("expected nothing done, got %d file(s) and %v", n, opts.done)
+	}
+	if _, err := os.Stat(dst); err == nil {
+		t.Error("expected no copy to be made")
+	}
+
+	n, err = renameAction(context.Background(), map[string]string{src: dst}, opts)
+	if err != nil || n != 1 || !opts.done[src] {
+		t.Errorf("expected the rename to be recorded, got %d file(s), %v and %v", n, err, opts.done)
+	}
+}
+
+// TestMidCycle verifies a cycle isn't left with a file under a temporary name.
+func TestMidCycle(t *testing.T) {
+	tempDir := t.TempDir()
+	a := filepath.Join(tempDir, "a")
+	b := filepath.Join(tempDir, "b")
+	moves := chainOrder(map[string]string{a: b, b: a}, nil, nil)
+	if len(moves) != 3 {
+		t.Fatalf("expected 3 moves for a swap, got %v", moves)
+	}
+	for i, expected := range []bool{false, true, true} {
+		if got := midCycle(moves, i); got != expected {
+			t.Errorf("move %d: expected %v, got %v", i, expected, got)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// rewrite is a text file whose references to renamed files are updated.
+type rewrite struct {
+	// path is where the text file is, and dst where the plan puts it.
+	path, dst string
+	old, new  []byte
+	// count is how many references were updated, and refs maps the ones
+	// found to what they became.
+	count int
+	refs  map[string]string
+}
+
+// pendingRewrite is a rewrite as it waits for the files to be renamed, and
+// as the run state keeps it until then: where the text file ends up, the
+// references to replace in it, and the checksums of its content before and
+// after, which tell a rewrite already applied from a file changed since.
+type pendingRewrite struct {
+	Path   string            `json:"path"`
+	Refs   map[string]string `json:"refs"`
+	Before string            `json:"before"`
+	After  string            `json:"after"`
+}
+
+func (r rewrite) pending() pendingRewrite {
+	return pendingRewrite{Path: r.dst, Refs: r.refs, Before: checksum(r.old), After: checksum(r.new)}
+}
+
+func checksum(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// parseRewritePatterns splits the comma separated name patterns of the text
+// files to update references in.
+func parseRewritePatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// planRewrites finds the text files under roots whose names match the
+// patterns, and works out their contents with the references to the
+// sources of pairs replaced by their destinations. A reference is either
+// the path of the source relative to the text file, or its name where no
+// two sources with that name get different ones and no other file by that
+// name sits next to the text file.
+func planRewrites(ctx context.Context, fsys fileSystem, roots, patterns []string, pairs map[string]string) ([]rewrite, error) {
+	finder := newReferenceFinder(fsys, pairs)
+	var rewrites []rewrite
+	for _, root := range roots {
+		err := fsys.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err := stopped(ctx, "rewrite", uint(len(rewrites))); err != nil {
+				return err
+			}
+			switch {
+			case err != nil:
+				return err
+			case d.IsDir() && d.Name() == ".git":
+				return fs.SkipDir
+			case !d.Type().IsRegular() || isPartial(d.Name()) || isParked(d.Name()):
+				return nil
+			case !slices.ContainsFunc(patterns, func(pattern string) bool {
+				ok, _ := filepath.Match(pattern, d.Name())
+				return ok
+			}):
+				return nil
+			}
+
+			data, err := readText(fsys, p)
+			if err != nil || data == nil {
+				return err
+			}
+			r := rewrite{path: p, dst: cmp.Or(pairs[p], p), old: data}
+			r.new, r.refs, r.count = replaceReferences(data, finder.re, finder.find(p))
+			if r.count > 0 {
+				rewrites = append(rewrites, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return rewrites, err
+		}
+	}
+	return rewrites, nil
+}
+
+// readText returns the content of the file, or nil if it isn't text, by
+// the way git tells: a NUL byte in the first 8000.
+func readText(fsys fileSystem, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open file(%q): %w", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("read file(%q): %w", name, err)
+	}
+	if bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// referenceFinder finds the references to the sources of a plan. The names
+// of the sources are searched for with one regex built for the whole plan,
+// and each name found is tried against the references ending in it, which
+// are worked out once for each dir text files are in.
+type referenceFinder struct {
+	fsys  fileSystem
+	pairs map[string]string
+	// names maps the name of each source to the one its destination gets,
+	// or "" if two sources with that name get different ones.
+	names map[string]string
+	re    *regexp.Regexp
+	// dirs holds the references of the text files in a dir that end up in
+	// another.
+	dirs map[[2]string]referenceSet
+}
+
+func newReferenceFinder(fsys fileSystem, pairs map[string]string) *referenceFinder {
+	names := make(map[string]string)
+	for src, dst := range pairs {
+		oldName, newName := filepath.Base(src), filepath.Base(dst)
+		if prev, ok := names[oldName]; ok && prev != newName {
+			names[oldName] = ""
+			continue
+		}
+		names[oldName] = newName
+	}
+	return &referenceFinder{
+		fsys:  fsys,
+		pairs: pairs,
+		names: names,
+		re:    namesRegexp(slices.Collect(maps.Keys(names))),
+		dirs:  make(map[[2]string]referenceSet),
+	}
+}
+
+// find returns the references the text file at p may have.
+func (f *referenceFinder) find(p string) referenceSet {
+	dirs := [2]string{filepath.Dir(p), filepath.Dir(cmp.Or(f.pairs[p], p))}
+	refs, ok := f.dirs[dirs]
+	if !ok {
+		refs = groupReferences(references(f.fsys, dirs[0], dirs[1], f.pairs, f.names))
+		f.dirs[dirs] = refs
+	}
+	return refs
+}
+
+// references maps what a text file in oldDir may call the sources of pairs
+// to what it should call their destinations once it is in newDir. A bare
+// name is left out where it is that of a file in oldDir the plan leaves
+// alone, as that is the file it refers to.
+func references(fsys fileSystem, oldDir, newDir string, pairs, names map[string]string) map[string]string {
+	refs := make(map[string]string)
+	for src, dst := range pairs {
+		from, err := filepath.Rel(oldDir, src)
+		if err != nil {
+			continue
+		}
+		to, err := filepath.Rel(newDir, dst)
+		if err != nil {
+			continue
+		}
+		if from, to := filepath.ToSlash(from), filepath.ToSlash(to); from != to {
+			refs[from] = to
+		}
+	}
+	for oldName, newName := range names {
+		if _, ok := refs[oldName]; ok || newName == "" || newName == oldName {
+			continue
+		}
+		if _, err := fsys.Lstat(filepath.Join(oldDir, oldName)); err == nil {
+			continue
+		}
+		refs[oldName] = newName
+	}
+	return refs
+}
+
+// referenceSet maps names to the references ending in them, longest first.
+type referenceSet map[string][]reference
+
+type reference struct {
+	old, new string
+}
+
+func groupReferences(refs map[string]string) referenceSet {
+	set := make(referenceSet)
+	for old, new := range refs {
+		name := path.Base(old)
+		set[name] = append(set[name], reference{old: old, new: new})
+	}
+	for _, group := range set {
+		slices.SortFunc(group, func(a, b reference) int {
+			return cmp.Or(cmp.Compare(len(b.old), len(a.old)), strings.Compare(a.old, b.old))
+		})
+	}
+	return set
+}
+
+// namesRegexp matches any of the names, longest first.
+func namesRegexp(names []string) *regexp.Regexp {
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	names = slices.Compact(names)
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	return regexp.MustCompile(strings.Join(quoted, "|"))
+}
+
+// replaceReferences replaces the references of refs in data, at the names
+// re finds, and returns the ones it replaced and how many times. The
+// longest reference ending at a name wins, and only whole names count, so
+// a.png is not replaced inside data.png or a.png.bak.
+func replaceReferences(data []byte, re *regexp.Regexp, refs referenceSet) ([]byte, map[string]string, int) {
+	if len(refs) == 0 {
+		return data, nil, 0
+	}
+	var out bytes.Buffer
+	var count, last int
+	found := make(map[string]string)
+	for _, m := range re.FindAllIndex(data, -1) {
+		if !endsName(data, m[1]) {
+			continue
+		}
+		for _, ref := range refs[string(data[m[0]:m[1]])] {
+			start := m[1] - len(ref.old)
+			if start < last || string(data[start:m[1]]) != ref.old || !startsName(data, start) {
+				continue
+			}
+			out.Write(data[last:start])
+			out.WriteString(ref.new)
+			last = m[1]
+			found[ref.old] = ref.new
+			count++
+			break
+		}
+	}
+	if count == 0 {
+		return data, nil, 0
+	}
+	out.Write(data[last:])
+	return out.Bytes(), found, count
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+// startsName reports whether a name can start at i.
+func startsName(data []byte, i int) bool {
+	return i == 0 || !isNameByte(data[i-1]) && data[i-1] != '.'
+}
+
+// endsName reports whether a name can end at i. A dot may follow it, as at
+// the end of a sentence, but not another extension.
+func endsName(data []byte, i int) bool {
+	switch {
+	case i == len(data):
+		return true
+	case data[i] == '.':
+		return i+1 == len(data) || !isNameByte(data[i+1])
+	default:
+		return !isNameByte(data[i])
+	}
+}
+
+// applyRewrites replaces the references in the text files, once they are
+// where the plan puts them. Each is written to a partial file renamed over
+// the old one, keeping its permissions. Files already rewritten are left
+// alone, so a resumed run can apply them all again, while those changed
+// since the plan fail.
+func applyRewrites(fsys fileSystem, rewrites []pendingRewrite) error {
+	var names []string
+	for _, r := range rewrites {
+		for old := range r.Refs {
+			names = append(names, path.Base(old))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	re := namesRegexp(names)
+	for _, r := range rewrites {
+		data, err := readText(fsys, r.Path)
+		if err != nil {
+			return err
+		}
+		switch checksum(data) {
+		case r.After:
+			continue
+		case r.Before:
+		default:
+			return fmt.Errorf("file(%q) changed since the references in it were planned", r.Path)
+		}
+		data, _, _ = replaceReferences(data, re, groupReferences(r.Refs))
+		if checksum(data) != r.After {
+			return fmt.Errorf("file(%q): references replaced differently than planned", r.Path)
+		}
+		if err := writeRewrite(fsys, r.Path, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRewrite(fsys fileSystem, name string, data []byte) error {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return fmt.Errorf("get file(%q) info: %w", name, err)
+	}
+	out, err := createPartial(fsys, name)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer out.discard()
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("write file(%q): %w", name, err)
+	}
+	if err := out.Chmod(info.Mode()); err != nil {
+		return fmt.Errorf("set file(%q) permissions: %w", name, err)
+	}
+	if err := out.commit(true); err != nil {
+		return fmt.Errorf("replace file(%q): %w", name, err)
+	}
+	return nil
+}
+
+// diff is the lines of the rewrite that changed, in the unified format.
+// References never span lines, so the lines pair up one to one.
+func (r rewrite) diff() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", r.path, r.dst)
+	oldLines := strings.Split(string(r.old), "\n")
+	newLines := strings.Split(string(r.new), "\n")
+	for i := range min(len(oldLines), len(newLines)) {
+		if oldLines[i] == newLines[i] {
+			continue
+		}
+		fmt.Fprintf(&b, "@@ -%d +%d @@\n-%s\n+%s\n", i+1, i+1, oldLines[i], newLines[i])
+	}
+	return b.String()
+}
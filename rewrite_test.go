@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestReplaceReferences verifies only whole names are replaced, longest first.
+func TestReplaceReferences(t *testing.T) {
+	refs := map[string]string{
+		"a.png":     "b.png",
+		"img/a.png": "pics/b.png",
+	}
+	tests := []struct {
+		in, out string
+		count   int
+	}{
+		{"![](a.png)", "![](b.png)", 1},
+		{"see a.png.", "see b.png.", 1},
+		{`<img src="./img/a.png">`, `<img src="./pics/b.png">`, 1},
+		{"data.png a.png.bak x-a.png xa.png", "data.png a.png.bak x-a.png xa.png", 0},
+		{"a.png,a.png", "b.png,b.png", 2},
+	}
+	re := namesRegexp([]string{"a.png"})
+	for _, tt := range tests {
+		out, _, count := replaceReferences([]byte(tt.in), re, groupReferences(refs))
+		if string(out) != tt.out || count != tt.count {
+			t.Errorf("%q: expected %q (%d), got %q (%d)", tt.in, tt.out, tt.count, out, count)
+		}
+	}
+}
+
+// TestRewriteReferences verifies references are updated relative to where the text files end up.
+func TestRewriteReferences(t *testing.T) {
+	m := newMemFS()
+	files := map[string]string{
+		"site/img/aaa_logo.png":  "png",
+		"site/a/aaa_same.txt":    "a",
+		"site/b/aaa_same.txt":    "b",
+		"site/index.md":          "![](img/aaa_logo.png)\n[a](a/aaa_same.txt) and aaa_same.txt\n",
+		"site/docs/aaa_guide.md": "![](../img/aaa_logo.png)\n",
+		"site/notes.txt":         "aaa_logo.png\n",
+		"site/page.bin":          "aaa_logo.png\x00",
+	}
+	for name, content := range files {
+		if err := m.WriteFile(filepath.FromSlash(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join("site", filepath.FromSlash(name)) }
+	pairs := map[string]string{
+		path("img/aaa_logo.png"):  path("img/logo.png"),
+		path("a/aaa_same.txt"):    path("a/same-a.txt"),
+		path("b/aaa_same.txt"):    path("b/same-b.txt"),
+		path("docs/aaa_guide.md"): path("guide.md"),
+	}
+
+	rewrites, err := planRewrites(context.Background(), m, []string{"site"}, []string{"*.md", "*.bin"}, pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, r := range rewrites {
+		paths = append(paths, r.path)
+	}
+	if expected := []string{path("docs/aaa_guide.md"), path("index.md")}; !slices.Equal(paths, expected) {
+		t.Fatalf("expected rewrites of %v, got %v", expected, paths)
+	}
+	if n, err := renameAction(context.Background(), pairs, actionOptions{fsys: m}); err != nil || n != 4 {
+		t.Fatalf("expected 4 files renamed, got %d and %v", n, err)
+	}
+	var pending []pendingRewrite
+	for _, r := range rewrites {
+		pending = append(pending, r.pending())
+	}
+	// Applying them again leaves the files alone, as a resumed run does.
+	for range 2 {
+		if err := applyRewrites(m, pending); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		// The guide moves up next to img, and the name shared by two files
+		// is left alone.
+		"guide.md":  "![](img/logo.png)\n",
+		"index.md":  "![](img/logo.png)\n[a](a/same-a.txt) and aaa_same.txt\n",
+		"notes.txt": "aaa_logo.png\n",
+		"page.bin":  "aaa_logo.png\x00",
+	}
+	for name, content := range expected {
+		if data, err := m.ReadFile(path(name)); err != nil || string(data) != content {
+			t.Errorf("%s: expected %q, got %q and %v", name, content, data, err)
+		}
+	}
+}
+
+// TestRewriteSameNameOutsidePlan verifies a bare name is left alone next to a file by that name the plan doesn't touch.
+func TestRewriteSameNameOutsidePlan(t *testing.T) {
+	m := newMemFS()
+	files := map[string]string{
+		"img/logo_old.png":   "png",
+		"img/notes.md":       "![](logo_old.png)\n",
+		"other/logo_old.png": "other",
+		"other/notes.md":     "![](logo_old.png)\n",
+	}
+	for name, content := range files {
+		if err := m.WriteFile(filepath.FromSlash(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pairs := map[string]string{filepath.Join("img", "logo_old.png"): filepath.Join("img", "logo.png")}
+
+	rewrites, err := planRewrites(context.Background(), m, []string{"."}, []string{"*.md"}, pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewrites) != 1 || rewrites[0].path != filepath.Join("img", "notes.md") {
+		t.Fatalf("expected only img/notes.md to be rewritten, got %v", rewrites)
+	}
+	if got := string(rewrites[0].new); got != "![](logo.png)\n" {
+		t.Errorf("expected %q, got %q", "![](logo.png)\n", got)
+	}
+}
+
+// TestRewriteDiff verifies the changed lines are shown in the unified format.
+func TestRewriteDiff(t *testing.T) {
+	r := rewrite{
+		path: "old.md",
+		dst:  "new.md",
+		old:  []byte("title\n![](a.png)\nend\n"),
+		new:  []byte("title\n![](b.png)\nend\n"),
+	}
+	expected := "--- old.md\n+++ new.md\n@@ -2 +2 @@\n-![](a.png)\n+![](b.png)\n"
+	if got := r.diff(); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// TestRewriteChangedSincePlan verifies a text file edited after the plan is not rewritten.
+func TestRewriteChangedSincePlan(t *testing.T) {
+	m := newMemFS()
+	if err := m.WriteFile("index.md", []byte("![](aaa_a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pairs := map[string]string{"aaa_a.png": "a.png"}
+	rewrites, err := planRewrites(context.Background(), m, []string{"."}, []string{"*.md"}, pairs)
+	if err != nil || len(rewrites) != 1 {
+		t.Fatalf("expected 1 rewrite, got %d and %v", len(rewrites), err)
+	}
+	if err := m.WriteFile("index.md", []byte("edited ![](aaa_a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRewrites(m, []pendingRewrite{rewrites[0].pending()}); err == nil {
+		t.Error("expected an error for a file changed since the plan")
+	}
+	if data, _ := m.ReadFile("index.md"); string(data) != "edited ![](aaa_a.png)\n" {
+		t.Errorf("expected the file to be left alone, got %q", data)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// rulesFile is the layout of a rules file, in either YAML or TOML.
+type rulesFile struct {
+	Path        string      `yaml:"path" toml:"path"`
+	Paths       []string    `yaml:"paths" toml:"paths"`
+	Find        string      `yaml:"find" toml:"find"`
+	Regex       bool        `yaml:"regex" toml:"regex"`
+	Replace     string      `yaml:"replace" toml:"replace"`
+	Filters     rulesFilter `yaml:"filters" toml:"filters"`
+	Operations  []string    `yaml:"operations" toml:"operations"`
+	Sanitize    string      `yaml:"sanitize" toml:"sanitize"`
+	OnConflict  string      `yaml:"on-conflict" toml:"on-conflict"`
+	SuffixFmt   string      `yaml:"suffix-format" toml:"suffix-format"`
+	Dedupe      string      `yaml:"dedupe" toml:"dedupe"`
+	Sort        string      `yaml:"sort" toml:"sort"`
+	Action      string      `yaml:"action" toml:"action"`
+	Output      string      `yaml:"output" toml:"output"`
+	KeepTree    bool        `yaml:"keep-tree" toml:"keep-tree"`
+	Verify      bool        `yaml:"verify" toml:"verify"`
+	Reflink     string      `yaml:"reflink" toml:"reflink"`
+	S3Endpoint  string      `yaml:"s3-endpoint" toml:"s3-endpoint"`
+	Git         bool        `yaml:"git" toml:"git"`
+	GitUntrack  bool        `yaml:"git-untracked" toml:"git-untracked"`
+	Rewrite     string      `yaml:"rewrite" toml:"rewrite"`
+	Retries     int         `yaml:"verify-retries" toml:"verify-retries"`
+	Verbose     bool        `yaml:"verbose" toml:"verbose"`
+	DryRun      bool        `yaml:"dry-run" toml:"dry-run"`
+	Interactive bool        `yaml:"interactive" toml:"interactive"`
+}
+
+type rulesFilter struct {
+	Type      string `yaml:"type" toml:"type"`
+	MinSize   string `yaml:"min-size" toml:"min-size"`
+	MaxSize   string `yaml:"max-size" toml:"max-size"`
+	NewerThan string `yaml:"newer-than" toml:"newer-than"`
+	OlderThan string `yaml:"older-than" toml:"older-than"`
+	AgeBy     string `yaml:"age-by" toml:"age-by"`
+	Owner     string `yaml:"owner" toml:"owner"`
+	Group     string `yaml:"group" toml:"group"`
+	Perm      string `yaml:"perm" toml:"perm"`
+	Kind      string `yaml:"kind" toml:"kind"`
+}
+
+// lineError is a validation error tied to a line of the rules file.
+type lineError struct {
+	file string
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	if e.line == 0 {
+		return fmt.Sprintf("%s: %v", e.file, e.err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.file, e.line, e.err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.err
+}
+
+// loadRules reads a rules file into a config. The format is picked by the
+// file extension, and every validation error points at the offending line.
+func loadRules(path string) (config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config{}, fmt.Errorf("read rules: %w", err)
+	}
+
+	var rules rulesFile
+	var lines map[string]int
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		rules, lines, err = decodeYAMLRules(data)
+	case ".toml":
+		rules, lines, err = decodeTOMLRules(data)
+	default:
+		return config{}, fmt.Errorf("unknown rules format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return config{}, inFile(path, err)
+	}
+
+	cfg, err := rules.config(lines)
+	if err != nil {
+		return config{}, inFile(path, err)
+	}
+	return cfg, nil
+}
+
+// inFile names the rules file in err.
+func inFile(path string, err error) error {
+	var le *lineError
+	if errors.As(err, &le) {
+		le.file = path
+		return err
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+func decodeYAMLRules(data []byte) (rulesFile, map[string]int, error) {
+	var rules rulesFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&rules); err != nil {
+		return rules, nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return rules, nil, err
+	}
+	lines := make(map[string]int)
+	if len(root.Content) > 0 {
+		yamlLines(root.Content[0], "", lines)
+	}
+	return rules, lines, nil
+}
+
+// yamlLines records the line of every key and list item under node, keyed by
+// its dotted path such as "filters.type" or "operations.2".
+func yamlLines(node *yaml.Node, prefix string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := prefix + node.Content[i].Value
+			lines[key] = node.Content[i].Line
+			yamlLines(node.Content[i+1], key+".", lines)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			key := prefix + strconv.Itoa(i)
+			lines[key] = item.Line
+			yamlLines(item, key+".", lines)
+		}
+	}
+}
+
+func decodeTOMLRules(data []byte) (rulesFile, map[string]int, error) {
+	var rules rulesFile
+	md, err := toml.Decode(string(data), &rules)
+	if err != nil {
+		return rules, nil, err
+	}
+
+	lines := tomlLines(data)
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		key := undecoded[0].String()
+		return rules, nil, &lineError{
+			line: lines[key], err: fmt.Errorf("unknown key %q", key),
+		}
+	}
+	return rules, lines, nil
+}
+
+var (
+	tomlTable = regexp.MustCompile(`^\s*\[\s*([A-Za-z0-9_.-]+)\s*\]`)
+	tomlKey   = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=`)
+)
+
+// tomlLines records the line each key is defined on. The decoder doesn't
+// expose positions, but the rules layout is shallow enough to find them by
+// scanning the tables and keys.
+func tomlLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	var table string
+	for i, line := range strings.Split(string(data), "\n") {
+		if m := tomlTable.FindStringSubmatch(line); m != nil {
+			table = m[1] + "."
+			lines[m[1]] = i + 1
+			continue
+		}
+		if m := tomlKey.FindStringSubmatch(line); m != nil {
+			lines[table+m[1]] = i + 1
+		}
+	}
+	return lines
+}
+
+// config validates the rules and turns them into a config.
+func (r rulesFile) config(lines map[string]int) (config, error) {
+	at := func(key string, err error) error {
+		line, ok := lines[key]
+		if !ok {
+			parent, _, _ := strings.Cut(key, ".")
+			line = lines[parent]
+		}
+		return &lineError{line: line, err: err}
+	}
+
+	var cfg config
+	switch {
+	case r.Path != "" && len(r.Paths) > 0:
+		return cfg, at("paths", fmt.Errorf("use either path or paths"))
+	case r.Path != "":
+		cfg.paths = []string{r.Path}
+	default:
+		cfg.paths = r.Paths
+	}
+	for i, p := range cfg.paths {
+		if p == "" {
+			return cfg, at("paths."+strconv.Itoa(i), fmt.Errorf("empty path"))
+		}
+	}
+
+	if r.Regex {
+		if _, err := regexp.Compile(r.Find); err != nil {
+			return cfg, at("find", fmt.Errorf("compile pattern: %w", err))
+		}
+	}
+	if r.Filters.Type != "" && !strings.HasPrefix(r.Filters.Type, ".") {
+		return cfg, at("filters.type", fmt.Errorf(
+			"file type must start with a dot, like %q", "."+r.Filters.Type,
+		))
+	}
+	filters := []struct {
+		key  string
+		opts filterOptions
+	}{
+		{"min-size", filterOptions{minSize: r.Filters.MinSize}},
+		{"max-size", filterOptions{maxSize: r.Filters.MaxSize}},
+		{"newer-than", filterOptions{newerThan: r.Filters.NewerThan}},
+		{"older-than", filterOptions{olderThan: r.Filters.OlderThan}},
+		{"age-by", filterOptions{ageBy: r.Filters.AgeBy}},
+		{"owner", filterOptions{owner: r.Filters.Owner}},
+		{"group", filterOptions{group: r.Filters.Group}},
+		{"perm", filterOptions{perm: r.Filters.Perm}},
+		{"kind", filterOptions{kind: r.Filters.Kind}},
+	}
+	for _, f := range filters {
+		if _, err := parseFilter(f.opts, time.Now()); err != nil {
+			return cfg, at("filters."+f.key, err)
+		}
+	}
+
+	for i, spec := range r.Operations {
+		if _, err := parseOperations([]string{spec}); err != nil {
+			return cfg, at("operations."+strconv.Itoa(i), err)
+		}
+	}
+	profile, err := getSanitizeProfile(r.Sanitize)
+	if err != nil {
+		return cfg, at("sanitize", err)
+	}
+
+	policy, err := getConflictPolicy(r.OnConflict)
+	if err != nil {
+		return cfg, at("on-conflict", err)
+	}
+	if r.SuffixFmt != "" {
+		if err := validateSuffixFormat(r.SuffixFmt); err != nil {
+			return cfg, at("suffix-format", err)
+		}
+	}
+	dedupe, err := getDedupeMode(r.Dedupe)
+	if err != nil {
+		return cfg, at("dedupe", err)
+	}
+	if dedupe == LINK && r.Output == "" {
+		return cfg, at("dedupe", fmt.Errorf("link needs an output"))
+	}
+	sortBy, err := getSortKey(r.Sort)
+	if err != nil {
+		return cfg, at("sort", err)
+	}
+	reflink, err := getReflinkMode(r.Reflink)
+	if err != nil {
+		return cfg, at("reflink", err)
+	}
+	if r.S3Endpoint != "" {
+		if _, err := parseS3Endpoint(r.S3Endpoint); err != nil {
+			return cfg, at("s3-endpoint", err)
+		}
+	}
+	if r.Verify && r.Output == "" {
+		return cfg, at("verify", fmt.Errorf("verify needs an output"))
+	}
+	if r.Retries < 0 {
+		return cfg, at("verify-retries", fmt.Errorf("retries can't be negative"))
+	}
+	if r.KeepTree && r.Output == "" {
+		return cfg, at("keep-tree", fmt.Errorf("keep-tree needs an output"))
+	}
+	if r.GitUntrack && !r.Git {
+		return cfg, at("git-untracked", fmt.Errorf("git-untracked needs git"))
+	}
+	if _, err := parseRewritePatterns(r.Rewrite); err != nil {
+		return cfg, at("rewrite", err)
+	}
+
+	switch strings.ToLower(r.Action) {
+	case "", RENAME:
+		if r.Output != "" && r.Action != "" {
+			return cfg, at("action", fmt.Errorf("rename doesn't take an output"))
+		}
+	case "cp", COPY, "mv", MOVE:
+		if r.Output == "" {
+			return cfg, at("action", fmt.Errorf("%s needs an output", r.Action))
+		}
+	default:
+		return cfg, at("action", fmt.Errorf("unknown action %q", r.Action))
+	}
+
+	cfg.options = fileOptions{
+		str:              r.Find,
+		fileType:         r.Filters.Type,
+		replace:          r.Replace,
+		output:           r.Output,
+		transmissionType: strings.ToLower(r.Action),
+		sanitize:         profile,
+		operations:       r.Operations,
+		onConflict:       policy,
+		suffixFormat:     r.SuffixFmt,
+		dedupe:           dedupe,
+		keepTree:         r.KeepTree,
+		sortBy:           sortBy,
+		verify:           r.Verify,
+		verifyRetries:    r.Retries,
+		reflink:          reflink,
+		s3Endpoint:       r.S3Endpoint,
+		git:              r.Git,
+		gitUntracked:     r.GitUntrack,
+		rewrite:          r.Rewrite,
+		filters: filterOptions{
+			minSize:   r.Filters.MinSize,
+			maxSize:   r.Filters.MaxSize,
+			newerThan: r.Filters.NewerThan,
+			olderThan: r.Filters.OlderThan,
+			ageBy:     r.Filters.AgeBy,
+			owner:     r.Filters.Owner,
+			group:     r.Filters.Group,
+			perm:      r.Filters.Perm,
+			kind:      r.Filters.Kind,
+		},
+	}
+	cfg.withRegex = r.Regex
+	cfg.withVerbose = r.Verbose
+	cfg.withDryRun = r.DryRun
+	cfg.withInteractive = r.Interactive
+	return cfg, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLoadRulesYAML verifies a YAML rules file is loaded into the config.
+func TestLoadRulesYAML(t *testing.T) {
+	tempDir := t.TempDir()
+	path := createTempFile(t, tempDir, "rules.yaml", `
+paths:
+  - ./drops/a
+  - ./drops/b
+find: "\\d+"
+regex: true
+filters:
+  type: .jpg
+operations:
+  - case:lower
+  - insert:0:new_
+sanitize: windows
+action: move
+output: ./sorted
+dry-run: true
+`)
+
+	cfg, err := loadRules(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if len(cfg.paths) != 2 || cfg.paths[1] != "./drops/b" {
+		t.Errorf("expected two paths, got %v", cfg.paths)
+	}
+	if cfg.options.str != `\d+` || !cfg.withRegex {
+		t.Errorf("expected regex %q, got %q", `\d+`, cfg.options.str)
+	}
+	if cfg.options.fileType != ".jpg" {
+		t.Errorf("expected file type %q, got %q", ".jpg", cfg.options.fileType)
+	}
+	if len(cfg.options.operations) != 2 {
+		t.Errorf("expected 2 operations, got %v", cfg.options.operations)
+	}
+	if getActionName(cfg.options.output, cfg.options.transmissionType) != MOVE {
+		t.Errorf("expected action %q, got %q", MOVE, cfg.options.transmissionType)
+	}
+	if cfg.options.sanitize != WINDOWS || !cfg.withDryRun {
+		t.Errorf("expected sanitize and dry-run to be set, got %+v", cfg)
+	}
+}
+
+// TestParseArgsOverRules verifies the -op flags given replace the operations of the rules file, and the others stay.
+func TestParseArgsOverRules(t *testing.T) {
+	path := createTempFile(t, t.TempDir(), "rules.yaml", `
+find: _A
+operations:
+  - case:lower
+  - trim
+`)
+	for _, tt := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"-c", path}, []string{"case:lower", "trim"}},
+		{[]string{"-c", path, "-op", "case:upper"}, []string{"case:upper"}},
+	} {
+		cfg, err := parseArgs(flag.NewFlagSet("omitter", flag.ContinueOnError), tt.args)
+		if err != nil {
+			t.Fatalf("parse %v: %v", tt.args, err)
+		}
+		if !slices.Equal(cfg.options.operations, tt.expected) || cfg.options.str != "_A" {
+			t.Errorf("%v: expected %v and %q, got %v and %q", tt.args, tt.expected, "_A", cfg.options.operations, cfg.options.str)
+		}
+	}
+}
+
+// TestLoadRulesTOML verifies a TOML rules file is loaded into the config.
+func TestLoadRulesTOML(t *testing.T) {
+	tempDir := t.TempDir()
+	path := createTempFile(t, tempDir, "rules.toml", `
+path = "./drops"
+find = "_final"
+replace = "_v1"
+operations = ["trim"]
+
+[filters]
+type = ".txt"
+`)
+
+	cfg, err := loadRules(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if len(cfg.paths) != 1 || cfg.paths[0] != "./drops" {
+		t.Errorf("expected one path, got %v", cfg.paths)
+	}
+	if cfg.options.replace != "_v1" || cfg.options.fileType != ".txt" {
+		t.Errorf("unexpected options %+v", cfg.options)
+	}
+}
+
+// TestLoadRulesRenumber verifies the renumber operation is accepted in a rules file.
+func TestLoadRulesRenumber(t *testing.T) {
+	path := createTempFile(t, t.TempDir(), "renumber.yaml", "path: .\noperations:\n  - renumber:1:1:4\n")
+	cfg, err := loadRules(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if len(cfg.options.operations) != 1 || cfg.options.operations[0] != "renumber:1:1:4" {
+		t.Errorf("expected the renumber operation, got %v", cfg.options.operations)
+	}
+	if _, err := loadRules(createTempFile(t, t.TempDir(), "bad.yaml", "path: .\noperations:\n  - renumber:x\n")); err == nil || !strings.Contains(err.Error(), "bad.yaml:3:") {
+		t.Errorf("expected a bad renumber to be reported at line 3, got %v", err)
+	}
+}
+
+// TestLoadRulesErrors verifies validation errors point at the offending line.
+func TestLoadRulesErrors(t *testing.T) {
+	cases := []struct {
+		name, content, expected string
+	}{
+		{"op.yaml", "path: .\noperations:\n  - trim\n  - shout:loud\n", "op.yaml:4:"},
+		{"action.yaml", "path: .\n\naction: copy\n", "action.yaml:3: copy needs an output"},
+		{"type.yml", "path: .\nfilters:\n  type: txt\n", "type.yml:3:"},
+		{"unknown.yaml", "path: .\ncolour: red\n", "line 2"},
+		{"regex.toml", "path = \".\"\nregex = true\nfind = \"(\"\n", "regex.toml:3:"},
+		{"unknown.toml", "path = \".\"\n[filters]\nsize = 3\n", "unknown.toml:3: unknown key"},
+		{"rules.json", "{}", "unknown rules format"},
+	}
+	tempDir := t.TempDir()
+	for _, c := range cases {
+		path := createTempFile(t, tempDir, c.name, c.content)
+		_, err := loadRules(path)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: expected error to contain %q, got %q", c.name, c.expected, err)
+		}
+	}
+}
+
+// TestLoadRulesMissing verifies a missing rules file is reported.
+func TestLoadRulesMissing(t *testing.T) {
+	if _, err := loadRules(filepath.Join(t.TempDir(), "none.yaml")); err == nil {
+		t.Error("expected an error for a missing rules file")
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Scheme starts the paths of -p and -output that are in a bucket.
+const s3Scheme = "s3://"
+
+// defaultS3Endpoint is where buckets are looked up unless -s3-endpoint says
+// otherwise.
+const defaultS3Endpoint = "https://s3.amazonaws.com"
+
+// s3Path returns the bucket/key form of an s3:// path, or false if path
+// isn't one.
+func s3Path(p string) (string, bool) {
+	rest, ok := strings.CutPrefix(p, s3Scheme)
+	if !ok {
+		return "", false
+	}
+	return filepath.FromSlash(strings.Trim(rest, "/")), true
+}
+
+func isS3Path(p string) bool {
+	return strings.HasPrefix(p, s3Scheme)
+}
+
+// s3Paths returns the bucket/key forms of the paths and the output. They
+// must all be s3:// paths, as files aren't copied between a bucket and the
+// local disk.
+func s3Paths(paths []string, output string) ([]string, string, error) {
+	keys := make([]string, 0, len(paths))
+	for _, p := range paths {
+		key, ok := s3Path(p)
+		if !ok || key == "" {
+			return nil, "", fmt.Errorf("%q must be in a bucket too, as in s3://bucket/prefix", p)
+		}
+		keys = append(keys, key)
+	}
+	if output == "" {
+		return keys, "", nil
+	}
+	out, ok := s3Path(output)
+	if !ok || out == "" {
+		return nil, "", fmt.Errorf("output %q must be in a bucket too, as in s3://bucket/prefix", output)
+	}
+	return keys, out, nil
+}
+
+// s3FS is the objects of S3 compatible storage. Paths are the bucket
+// followed by the key, as in photos/2024/IMG_0001.jpg, and folders are the
+// prefixes keys share. Objects can't be renamed in place, so renames and
+// moves are server side copies followed by deletes, and copies never go
+// through the client.
+//
+// Uploads and copies that mustn't overwrite are sent with If-None-Match, so
+// S3 itself refuses them if the destination was taken meanwhile. Not every
+// endpoint honours it, so the destination is also checked right before.
+type s3FS struct {
+	client   *minio.Client
+	endpoint string
+}
+
+// newS3FS connects to the endpoint, a URL like http://localhost:9000. The
+// credentials are read from the AWS or MinIO environment variables, or the
+// AWS credentials file.
+func newS3FS(endpoint string) (*s3FS, error) {
+	u, err := parseS3Endpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+	})
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:        creds,
+		Secure:       u.Scheme != "http",
+		Region:       os.Getenv("AWS_REGION"),
+		BucketLookup: minio.BucketLookupAuto,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3FS{client: client, endpoint: endpoint}, nil
+}
+
+func parseS3Endpoint(endpoint string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("endpoint %q must be a URL like https://host:port", endpoint)
+	}
+	return u, nil
+}
+
+// split returns the bucket and the key of name.
+func (s *s3FS) split(name string) (string, string) {
+	name = strings.Trim(filepath.ToSlash(filepath.Clean(name)), "/")
+	bucket, key, _ := strings.Cut(name, "/")
+	return bucket, key
+}
+
+// notExist turns the missing key and bucket responses into fs.ErrNotExist.
+func notExist(op, name string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket", "NotFound":
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	default:
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+}
+
+// WalkDir lists the objects under root, in the order of their keys. Only
+// root is given as a folder, as the walker doesn't look at the others.
+func (s *s3FS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fn(root, fs.FileInfoToDirEntry(info), nil)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	if err != nil || !info.IsDir() {
+		return err
+	}
+
+	bucket, prefix := s.split(root)
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	objects := s.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return fn(root, nil, notExist("list", root, object.Err))
+		}
+		if strings.HasSuffix(object.Key, "/") {
+			// A folder marker, not a file.
+			continue
+		}
+		name := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(object.Key, prefix)))
+		err := fn(name, fs.FileInfoToDirEntry(s3Info(object)), nil)
+		if err == fs.SkipDir || err == fs.SkipAll {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func s3Info(object minio.ObjectInfo) fs.FileInfo {
+	return memInfo{
+		name:  path.Base(object.Key),
+		size:  object.Size,
+		entry: memEntry{mode: 0644, modTime: object.LastModified},
+	}
+}
+
+func s3DirInfo(name string) fs.FileInfo {
+	return memInfo{name: filepath.Base(name), entry: memEntry{mode: fs.ModeDir | 0755}}
+}
+
+// Stat returns the info of an object, or of a folder if name is a bucket or
+// a prefix of keys.
+func (s *s3FS) Stat(name string) (fs.FileInfo, error) {
+	ctx := context.Background()
+	bucket, key := s.split(name)
+	if key == "" {
+		ok, err := s.client.BucketExists(ctx, bucket)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+		if !ok {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		return s3DirInfo(name), nil
+	}
+
+	object, err := s.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return s3Info(object), nil
+	}
+	if err := notExist("stat", name, err); !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts := minio.ListObjectsOptions{Prefix: key + "/", MaxKeys: 1}
+	for object := range s.client.ListObjects(ctx, bucket, opts) {
+		if object.Err != nil {
+			return nil, notExist("stat", name, object.Err)
+		}
+		return s3DirInfo(name), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *s3FS) Lstat(name string) (fs.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *s3FS) Open(name string) (readFile, error) {
+	ctx := context.Background()
+	bucket, key := s.split(name)
+	object, err := s.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, notExist("open", name, err)
+	}
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, notExist("open", name, err)
+	}
+	return &s3Reader{Object: object, info: s3Info(info)}, nil
+}
+
+// s3Reader reads an object by ranges, as it is seeked.
+type s3Reader struct {
+	*minio.Object
+	info fs.FileInfo
+}
+
+func (r *s3Reader) Stat() (fs.FileInfo, error) {
+	return r.info, nil
+}
+
+// Create uploads what is written, as the object appears once it is closed.
+// Closing fails if another object took name meanwhile.
+func (s *s3FS) Create(name string, perm fs.FileMode) (writeFile, error) {
+	if err := s.free("open", name); err != nil {
+		return nil, err
+	}
+	bucket, key := s.split(name)
+	pr, pw := io.Pipe()
+	w := &s3Writer{name: name, pw: pw, done: make(chan error, 1)}
+	var opts minio.PutObjectOptions
+	opts.SetMatchETagExcept("*")
+	go func() {
+		_, err := s.client.PutObject(context.Background(), bucket, key, pr, -1, opts)
+		pr.CloseWithError(err)
+		if conditionFailed(err) {
+			err = &fs.PathError{Op: "close", Path: name, Err: fs.ErrExist}
+		}
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// free fails if there is an object at name.
+func (s *s3FS) free(op, name string) error {
+	_, err := s.Stat(name)
+	switch {
+	case err == nil:
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	default:
+		return err
+	}
+}
+
+type s3Writer struct {
+	name string
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Name() string { return w.name }
+
+// Chmod does nothing, as objects have no permissions.
+func (w *s3Writer) Chmod(mode fs.FileMode) error { return nil }
+
+func (w *s3Writer) Sync() error { return nil }
+
+// Close finishes the upload and waits for it.
+func (w *s3Writer) Close() error {
+	if w.done == nil {
+		return w.err
+	}
+	w.pw.Close()
+	w.err = <-w.done
+	w.done = nil
+	return w.err
+}
+
+// s3CopyLimit is the largest object S3 copies in one request. Larger ones are
+// copied in parts of s3PartSize, which S3 puts back together.
+const (
+	s3CopyLimit = 5 << 30
+	s3PartSize  = 1 << 30
+)
+
+// Copy copies the object at src to dst on the server. Unless overwrite is
+// set, the copy is made only if dst is still free when it lands.
+func (s *s3FS) Copy(src, dst string, overwrite bool) error {
+	srcBucket, srcKey := s.split(src)
+	object, err := s.client.StatObject(context.Background(), srcBucket, srcKey, minio.StatObjectOptions{})
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: notExist("copy", src, err)}
+	}
+	if !overwrite {
+		if err := s.free("copy", dst); err != nil {
+			return err
+		}
+	}
+	err = s.copy(src, dst, object, !overwrite)
+	if !overwrite && minio.ToErrorResponse(err).Code == "NotImplemented" {
+		// The endpoint can't make the copy depend on dst, so the check
+		// above is all there is.
+		err = s.copy(src, dst, object, false)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case conditionFailed(err):
+		return &fs.PathError{Op: "copy", Path: dst, Err: fs.ErrExist}
+	default:
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: notExist("copy", src, err)}
+	}
+}
+
+// copy copies the object at src to dst, in parts if it is above s3CopyLimit. If
+// exclusive is set, S3 is asked to fail the copy when dst exists.
+func (s *s3FS) copy(src, dst string, object minio.ObjectInfo, exclusive bool) error {
+	ctx := context.Background()
+	core := minio.Core{Client: s.client}
+	srcBucket, srcKey := s.split(src)
+	dstBucket, dstKey := s.split(dst)
+	var opts minio.PutObjectOptions
+	if exclusive {
+		opts.SetMatchETagExcept("*")
+	}
+	if object.Size <= s3CopyLimit {
+		var header map[string]string
+		if exclusive {
+			header = map[string]string{"If-None-Match": "*"}
+		}
+		_, err := core.CopyObject(ctx, srcBucket, srcKey, dstBucket, dstKey, header, minio.CopySrcOptions{}, minio.PutObjectOptions{})
+		return err
+	}
+
+	id, err := core.NewMultipartUpload(ctx, dstBucket, dstKey, minio.PutObjectOptions{ContentType: object.ContentType})
+	if err != nil {
+		return err
+	}
+	var parts []minio.CompletePart
+	for start := int64(0); start < object.Size; start += s3PartSize {
+		var part minio.CompletePart
+		part, err = core.CopyObjectPart(ctx, srcBucket, srcKey, dstBucket, dstKey, id, len(parts)+1, start, min(s3PartSize, object.Size-start), nil)
+		if err != nil {
+			break
+		}
+		parts = append(parts, part)
+	}
+	if err == nil {
+		// Only the last request can be made on condition, so the parts are
+		// copied either way and thrown away if dst was taken meanwhile.
+		_, err = core.CompleteMultipartUpload(ctx, dstBucket, dstKey, id, parts, opts)
+	}
+	if err != nil {
+		core.AbortMultipartUpload(ctx, dstBucket, dstKey, id)
+	}
+	return err
+}
+
+// conditionFailed reports whether err is S3 refusing a write made only if
+// its destination was free.
+func conditionFailed(err error) bool {
+	return minio.ToErrorResponse(err).Code == "PreconditionFailed"
+}
+
+// Rename copies oldname to newname and deletes it.
+func (s *s3FS) Rename(oldname, newname string, overwrite bool) error {
+	if err := s.Copy(oldname, newname, overwrite); err != nil {
+		return err
+	}
+	return s.Remove(oldname)
+}
+
+// Link fails, as S3 has no links.
+func (s *s3FS) Link(oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+}
+
+func (s *s3FS) Remove(name string) error {
+	bucket, key := s.split(name)
+	if key == "" {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.ErrUnsupported}
+	}
+	err := s.client.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return notExist("remove", name, err)
+	}
+	return nil
+}
+
+// MkdirAll does nothing, as folders are only the prefixes of keys.
+func (s *s3FS) MkdirAll(name string, perm fs.FileMode) error {
+	return nil
+}
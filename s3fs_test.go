@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newFakeS3 starts an in-process S3 server holding the objects of the
+// bucket and connects to it. The requests go through wrap first, if given.
+func newFakeS3(t *testing.T, bucket string, objects map[string]string, wrap ...func(http.Handler) http.Handler) *s3FS {
+	t.Helper()
+	backend := s3mem.New()
+	handler := gofakes3.New(backend, gofakes3.WithLogger(gofakes3.DiscardLog())).Server()
+	for _, w := range wrap {
+		handler = w(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	if err := backend.CreateBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+	// The server sets the time of the objects it is sent, but not of those
+	// put in its backend.
+	meta := map[string]string{"Last-Modified": entryTime.Format(http.TimeFormat)}
+	for key, content := range objects {
+		if _, err := backend.PutObject(bucket, key, meta, bytes.NewReader([]byte(content)), int64(len(content)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	s3, err := newS3FS(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s3
+}
+
+// keys lists the objects under root, as slash separated paths.
+func keys(t *testing.T, s3 *s3FS, root string) []string {
+	t.Helper()
+	var got []string
+	err := s3.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			got = append(got, filepath.ToSlash(path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func readObject(t *testing.T, s3 *s3FS, name string) string {
+	t.Helper()
+	f, err := s3.Open(filepath.FromSlash(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestS3Paths verifies every path has to be in a bucket.
+func TestS3Paths(t *testing.T) {
+	paths, output, err := s3Paths([]string{"s3://photos/2024/", "s3://photos"}, "s3://backup/photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join("photos", "2024"), "photos"}
+	if !slices.Equal(paths, expected) || output != filepath.Join("backup", "photos") {
+		t.Errorf("expected %v and %s, got %v and %s", expected, filepath.Join("backup", "photos"), paths, output)
+	}
+	if _, _, err := s3Paths([]string{"s3://photos", "local"}, ""); err == nil {
+		t.Error("expected an error for a local path")
+	}
+	if _, _, err := s3Paths([]string{"s3://photos"}, "local"); err == nil {
+		t.Error("expected an error for a local output")
+	}
+}
+
+// TestRenameS3Objects verifies keys are planned and renamed by copy and delete, resolving conflicts.
+func TestRenameS3Objects(t *testing.T) {
+	s3 := newFakeS3(t, "bucket", map[string]string{
+		"data/aaa_1.txt":     "one",
+		"data/1.txt":         "taken",
+		"data/sub/aaa_2.txt": "two",
+		"other/aaa_3.txt":    "three",
+	})
+
+	cfg := config{options: fileOptions{path: "bucket/data", str: "aaa_"}, fsys: s3}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	opts := actionOptions{order: result.order, fsys: s3}
+	if n, err := renameAction(context.Background(), result.pairs, opts); err != nil || n != 2 {
+		t.Fatalf("expected 2 objects renamed, got %d and %v", n, err)
+	}
+
+	expected := []string{"bucket/data/1.txt", "bucket/data/1_1.txt", "bucket/data/sub/2.txt"}
+	if got := keys(t, s3, filepath.Join("bucket", "data")); !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if content := readObject(t, s3, "bucket/data/1_1.txt"); content != "one" {
+		t.Errorf("expected the renamed object to hold %q, got %q", "one", content)
+	}
+	if got := keys(t, s3, filepath.Join("bucket", "other")); !slices.Equal(got, []string{"bucket/other/aaa_3.txt"}) {
+		t.Errorf("expected objects outside the prefix to be left alone, got %v", got)
+	}
+}
+
+// TestCopyAndMoveS3Objects verifies objects are copied and moved on the server, checked by checksum.
+func TestCopyAndMoveS3Objects(t *testing.T) {
+	s3 := newFakeS3(t, "bucket", map[string]string{
+		"in/aaa_1.txt": "one",
+		"in/aaa_2.txt": "two",
+	})
+
+	src := filepath.Join("bucket", "in")
+	pairs := map[string]string{
+		filepath.Join(src, "aaa_1.txt"): filepath.Join("bucket", "out", "1.txt"),
+	}
+	opts := actionOptions{verify: true, fsys: s3}
+	if n, err := copyAction(context.Background(), pairs, opts); err != nil || n != 1 {
+		t.Fatalf("expected 1 object copied, got %d and %v", n, err)
+	}
+	if _, err := copyAction(context.Background(), pairs, opts); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected copying over an object to fail with %v, got %v", fs.ErrExist, err)
+	}
+
+	pairs = map[string]string{
+		filepath.Join(src, "aaa_2.txt"): filepath.Join("bucket", "out", "2.txt"),
+	}
+	if n, err := moveAction(context.Background(), pairs, opts); err != nil || n != 1 {
+		t.Fatalf("expected 1 object moved, got %d and %v", n, err)
+	}
+
+	if got := keys(t, s3, src); !slices.Equal(got, []string{"bucket/in/aaa_1.txt"}) {
+		t.Errorf("expected only the copied object to stay, got %v", got)
+	}
+	for name, content := range map[string]string{"bucket/out/1.txt": "one", "bucket/out/2.txt": "two"} {
+		if got := readObject(t, s3, name); got != content {
+			t.Errorf("%s: expected %q, got %q", name, content, got)
+		}
+	}
+}
+
+// TestCopyS3ObjectTakenMeanwhile verifies copies are made on condition that the destination is free, and fail if S3 finds it taken.
+func TestCopyS3ObjectTakenMeanwhile(t *testing.T) {
+	var conditions []string
+	taken := false
+	// The fake server ignores conditions on copies, so they are answered
+	// here as S3 would once another client has taken the destination.
+	refuse := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Amz-Copy-Source") != "" {
+				conditions = append(conditions, r.Header.Get("If-None-Match"))
+				if taken && r.Header.Get("If-None-Match") == "*" {
+					w.WriteHeader(http.StatusPreconditionFailed)
+					io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>taken</Message></Error>`)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	s3 := newFakeS3(t, "bucket", map[string]string{"in/1.txt": "one"}, refuse)
+
+	src := filepath.Join("bucket", "in", "1.txt")
+	if err := s3.Copy(src, filepath.Join("bucket", "out", "1.txt"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.Copy(src, filepath.Join("bucket", "out", "1.txt"), true); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"*", ""}; !slices.Equal(conditions, expected) {
+		t.Errorf("expected If-None-Match %q, got %q", expected, conditions)
+	}
+
+	taken = true
+	if err := s3.Copy(src, filepath.Join("bucket", "out", "2.txt"), false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected the copy to fail with %v, got %v", fs.ErrExist, err)
+	}
+}
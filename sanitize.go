@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	POSIX   string = "posix"
+	WINDOWS string = "windows"
+	SMB     string = "smb"
+	S3      string = "s3"
+)
+
+// sanitizeReplacement is put in place of every character the target profile
+// does not accept.
+const sanitizeReplacement = '_'
+
+// windowsReserved are device names that Windows refuses as a file name, with
+// or without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func getSanitizeProfile(profile string) (string, error) {
+	switch strings.ToLower(profile) {
+	case "":
+		return "", nil
+	case "posix":
+		return POSIX, nil
+	case "windows", "win":
+		return WINDOWS, nil
+	case "smb", "cifs":
+		return SMB, nil
+	case "s3":
+		return S3, nil
+	default:
+		return "", fmt.Errorf("unknown sanitize profile %q", profile)
+	}
+}
+
+// sanitizeName rewrites name so it is accepted by the given profile. An empty
+// profile returns the name untouched.
+func sanitizeName(name, profile string) string {
+	switch profile {
+	case POSIX:
+		return sanitizePosix(name)
+	case WINDOWS:
+		return sanitizeWindows(name)
+	case SMB:
+		return sanitizeWindows(strings.TrimLeft(name, " "))
+	case S3:
+		return sanitizeS3(name)
+	default:
+		return name
+	}
+}
+
+// sanitizePath sanitizes each element of a slash separated name, so names
+// may put files into folders.
+func sanitizePath(name, profile string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = sanitizeName(elem, profile)
+	}
+	return strings.Join(elems, "/")
+}
+
+// sanitizePosix keeps the name within the POSIX portable filename character
+// set, and avoids a leading hyphen which tools would take for a flag.
+func sanitizePosix(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '_', r == '-':
+			return r
+		default:
+			return sanitizeReplacement
+		}
+	}, name)
+	if strings.HasPrefix(name, "-") {
+		name = string(sanitizeReplacement) + name[1:]
+	}
+	return truncateName(name, 255)
+}
+
+func sanitizeWindows(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return sanitizeReplacement
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return string(sanitizeReplacement)
+	}
+
+	stem, rest, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = stem + string(sanitizeReplacement)
+		if rest != "" {
+			name += "." + rest
+		}
+	}
+	return truncateName(name, 255)
+}
+
+// sanitizeS3 replaces the characters AWS lists as ones to avoid in object
+// keys, along with the control characters. AWS lists the bytes above ASCII,
+// so every letter outside it is replaced, not only those of Latin-1.
+func sanitizeS3(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r > unicode.MaxASCII ||
+			strings.ContainsRune("\\{}^%`[]\"<>~#|/", r) {
+			return sanitizeReplacement
+		}
+		return r
+	}, name)
+	return truncateName(name, 1024)
+}
+
+// truncateName shortens the name to at most limit bytes, cutting from the
+// stem so the extension survives.
+func truncateName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) >= limit {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for len(stem)+len(ext) > limit {
+		_, size := utf8.DecodeLastRuneInString(stem)
+		stem = stem[:len(stem)-size]
+	}
+	return stem + ext
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSanitizeName verifies each profile rewrites the names it can't accept.
+func TestSanitizeName(t *testing.T) {
+	cases := []struct {
+		profile, name, expected string
+	}{
+		{WINDOWS, "report: final?.txt", "report_ final_.txt"},
+		{WINDOWS, "notes...", "notes"},
+		{WINDOWS, "CON.txt", "CON_.txt"},
+		{WINDOWS, "lpt1", "lpt1_"},
+		{WINDOWS, "console.txt", "console.txt"},
+		{WINDOWS, "tab\there.txt", "tab_here.txt"},
+		{SMB, "  padded .txt ", "padded .txt"},
+		{POSIX, "-résumé 2024.pdf", "_r_sum__2024.pdf"},
+		{S3, "a{b}^c%d.txt", "a_b__c_d.txt"},
+		{S3, "spaces and+plus.txt", "spaces and+plus.txt"},
+		{S3, "café ő 日.txt", "caf_ _ _.txt"},
+		{"", "any:thing?", "any:thing?"},
+	}
+	for _, c := range cases {
+		if got := sanitizeName(c.name, c.profile); got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.profile, c.expected, got)
+		}
+	}
+}
+
+// TestTruncateName verifies long names are cut from the stem, keeping the extension.
+func TestTruncateName(t *testing.T) {
+	name := strings.Repeat("a", 300) + ".txt"
+	got := truncateName(name, 255)
+	if len(got) != 255 {
+		t.Errorf("expected length 255, got %d", len(got))
+	}
+	if filepath.Ext(got) != ".txt" {
+		t.Errorf("expected extension to survive, got %q", got)
+	}
+}
+
+// TestWalkerWithSanitize verifies walker sanitizes names without a string to find.
+func TestWalkerWithSanitize(t *testing.T) {
+	tempDir := t.TempDir()
+	file1 := createTempFile(t, tempDir, "what?.txt", "dummy")
+	file2 := createTempFile(t, tempDir, "fine.txt", "dummy")
+
+	cfg := config{
+		options: fileOptions{path: tempDir, sanitize: WINDOWS},
+	}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	pairs := result.pairs
+	if filepath.Base(pairs[file1]) != "what_.txt" {
+		t.Errorf("expected %q to become %q, got %q", file1, "what_.txt", pairs[file1])
+	}
+	if _, ok := pairs[file2]; ok {
+		t.Errorf("did not expect file %s in pairs", file2)
+	}
+}
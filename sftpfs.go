@@ -0,0 +1,298 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpScheme starts the paths of -p and -output that are on a remote host.
+const sftpScheme = "sftp://"
+
+func isSFTPPath(p string) bool {
+	return strings.HasPrefix(p, sftpScheme)
+}
+
+// sftpPath splits an sftp://[user@]host[:port]/path into the host, as
+// user@host:port, and the path on it. Paths are absolute, unless they start
+// with /~/, which is the home dir of the user.
+func sftpPath(p string) (string, string, error) {
+	u, err := url.Parse(p)
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" {
+		return "", "", fmt.Errorf("%q must be like sftp://user@host/path", p)
+	}
+	name := cmp.Or(u.User.Username(), currentUser())
+	host := name + "@" + net.JoinHostPort(u.Hostname(), cmp.Or(u.Port(), "22"))
+	remote, ok := strings.CutPrefix(u.Path, "/~")
+	if ok {
+		remote = cmp.Or(strings.TrimPrefix(remote, "/"), ".")
+	}
+	return host, filepath.FromSlash(cmp.Or(remote, "/")), nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// sftpPaths returns the remote forms of the paths and the output, and the
+// host they are on. They must all be sftp:// paths on the same host, as
+// files aren't copied between hosts.
+func sftpPaths(paths []string, output string) ([]string, string, string, error) {
+	var host string
+	remote := func(p string) (string, error) {
+		h, r, err := sftpPath(p)
+		if err != nil {
+			return "", err
+		}
+		if host != "" && h != host {
+			return "", fmt.Errorf("%q must be on %s too", p, host)
+		}
+		host = h
+		return r, nil
+	}
+	remotes := make([]string, 0, len(paths))
+	for _, p := range paths {
+		r, err := remote(p)
+		if err != nil {
+			return nil, "", "", err
+		}
+		remotes = append(remotes, r)
+	}
+	if output == "" {
+		return remotes, "", host, nil
+	}
+	out, err := remote(output)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("output: %w", err)
+	}
+	return remotes, out, host, nil
+}
+
+// sftpFS is a remote host reached over SSH. Data is streamed through the
+// connection, while renames, moves and links are made by the host.
+//
+// SFTP doesn't tell a destination that exists apart from other failures, so
+// a destination is looked up when creating or renaming to it fails, and
+// right before renaming to it, as some servers replace it.
+type sftpFS struct {
+	client *sftp.Client
+	// host is user@host:port, for resuming.
+	host string
+}
+
+// dialSFTP logs in to host, user@host:port, with the keys of the SSH agent
+// or those in ~/.ssh. The host key must be in ~/.ssh/known_hosts.
+func dialSFTP(host string) (*sftpFS, error) {
+	name, addr, _ := strings.Cut(host, "@")
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+	// The agent signs while logging in, so it is let go once that is done.
+	var keys agent.Agent
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if agentConn, err := net.Dial("unix", sock); err == nil {
+			defer agentConn.Close()
+			keys = agent.NewClient(agentConn)
+		}
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            name,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeysCallback(sshSigners(keys, home))},
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", host, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("start sftp on %s: %w", host, err)
+	}
+	return &sftpFS{client: client, host: host}, nil
+}
+
+// sshSigners returns the keys of the SSH agent, if there is one, followed by
+// the unencrypted default keys in ~/.ssh.
+func sshSigners(keys agent.Agent, home string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		if keys != nil {
+			if agentSigners, err := keys.Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+		for _, key := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			data, err := os.ReadFile(filepath.Join(home, ".ssh", key))
+			if err != nil {
+				continue
+			}
+			if signer, err := ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+		return signers, nil
+	}
+}
+
+// sftpName returns name the way the host takes it.
+func sftpName(name string) string {
+	return filepath.ToSlash(name)
+}
+
+// WalkDir walks the tree at root in lexical order, like filepath.WalkDir.
+func (s *sftpFS) WalkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = s.walk(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (s *sftpFS) walk(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	infos, err := s.client.ReadDir(sftpName(path))
+	if err != nil {
+		if err := fn(path, d, err); err != nil {
+			if err == fs.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	slices.SortFunc(infos, func(a, b fs.FileInfo) int { return strings.Compare(a.Name(), b.Name()) })
+	for _, info := range infos {
+		name := filepath.Join(path, info.Name())
+		if err := s.walk(name, fs.FileInfoToDirEntry(info), fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sftpFS) Stat(name string) (fs.FileInfo, error) {
+	return s.client.Stat(sftpName(name))
+}
+
+func (s *sftpFS) Lstat(name string) (fs.FileInfo, error) {
+	return s.client.Lstat(sftpName(name))
+}
+
+func (s *sftpFS) Open(name string) (readFile, error) {
+	return s.client.Open(sftpName(name))
+}
+
+// Create creates the file exclusively. Files are synced where the host
+// supports it.
+func (s *sftpFS) Create(name string, perm fs.FileMode) (writeFile, error) {
+	f, err := s.client.OpenFile(sftpName(name), os.O_RDWR|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: s.exists(name, err)}
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		s.client.Remove(sftpName(name))
+		return nil, err
+	}
+	_, canSync := s.client.HasExtension("fsync@openssh.com")
+	return &sftpWriter{File: f, canSync: canSync}, nil
+}
+
+// exists returns fs.ErrExist if there is a file at name, or err otherwise.
+func (s *sftpFS) exists(name string, err error) error {
+	if _, statErr := s.client.Lstat(sftpName(name)); statErr == nil {
+		return fs.ErrExist
+	}
+	return err
+}
+
+type sftpWriter struct {
+	*sftp.File
+	canSync bool
+}
+
+func (w *sftpWriter) Sync() error {
+	if !w.canSync {
+		return nil
+	}
+	return w.File.Sync()
+}
+
+// Rename renames on the host. Replacing newname needs the posix-rename
+// extension of OpenSSH, or is a remove followed by a rename without it.
+func (s *sftpFS) Rename(oldname, newname string, overwrite bool) error {
+	var err error
+	switch _, posix := s.client.HasExtension("posix-rename@openssh.com"); {
+	case overwrite && posix:
+		err = s.client.PosixRename(sftpName(oldname), sftpName(newname))
+	case overwrite:
+		if err = s.client.Remove(sftpName(newname)); err == nil || errors.Is(err, fs.ErrNotExist) {
+			err = s.client.Rename(sftpName(oldname), sftpName(newname))
+		}
+	default:
+		if err = s.exists(newname, nil); err == nil {
+			if err = s.client.Rename(sftpName(oldname), sftpName(newname)); err != nil {
+				err = s.exists(newname, err)
+			}
+		}
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Move renames on the host, so moves don't stream the data through the
+// connection.
+func (s *sftpFS) Move(src, dst string, overwrite bool) error {
+	return s.Rename(src, dst, overwrite)
+}
+
+// Link links on the host, which needs the hardlink extension of OpenSSH.
+func (s *sftpFS) Link(oldname, newname string) error {
+	if err := s.client.Link(sftpName(oldname), sftpName(newname)); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: s.exists(newname, err)}
+	}
+	return nil
+}
+
+func (s *sftpFS) Remove(name string) error {
+	return s.client.Remove(sftpName(name))
+}
+
+func (s *sftpFS) MkdirAll(name string, perm fs.FileMode) error {
+	return s.client.MkdirAll(sftpName(name))
+}
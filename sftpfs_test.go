@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newPipeSFTP serves the local disk over SFTP in-process and connects to it.
+func newPipeSFTP(t *testing.T) *sftpFS {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return &sftpFS{client: client}
+}
+
+// TestSFTPPaths verifies the host and the remote paths are split out of the URLs.
+func TestSFTPPaths(t *testing.T) {
+	paths, output, host, err := sftpPaths(
+		[]string{"sftp://alice@files.example.com/srv/share", "sftp://alice@files.example.com:22/~/inbox"},
+		"sftp://alice@files.example.com/~",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.FromSlash("/srv/share"), "inbox"}
+	if !slices.Equal(paths, expected) || output != "." || host != "alice@files.example.com:22" {
+		t.Errorf("expected %v, . and alice@files.example.com:22, got %v, %s and %s", expected, paths, output, host)
+	}
+	if _, _, _, err := sftpPaths([]string{"sftp://alice@one/a", "sftp://alice@two/b"}, ""); err == nil {
+		t.Error("expected an error for paths on two hosts")
+	}
+	if _, _, _, err := sftpPaths([]string{"sftp://alice@one/a"}, "local"); err == nil {
+		t.Error("expected an error for a local output")
+	}
+}
+
+// TestSFTPKeepsExistingFiles verifies Create and Rename don't replace files unless asked to.
+func TestSFTPKeepsExistingFiles(t *testing.T) {
+	s := newPipeSFTP(t)
+	tempDir := t.TempDir()
+	a := createTempFile(t, tempDir, "a", "a")
+	b := createTempFile(t, tempDir, "b", "b")
+
+	if _, err := s.Create(a, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("create: expected %v, got %v", fs.ErrExist, err)
+	}
+	if err := s.Rename(a, b, false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("rename: expected %v, got %v", fs.ErrExist, err)
+	}
+	if err := s.Rename(a, b, true); err != nil {
+		t.Fatalf("rename with overwrite: %v", err)
+	}
+	if data, _ := os.ReadFile(b); string(data) != "a" {
+		t.Errorf("expected b to hold %q, got %q", "a", data)
+	}
+}
+
+// TestActionsOverSFTP verifies files are planned, renamed, copied and moved on the remote host.
+func TestActionsOverSFTP(t *testing.T) {
+	s := newPipeSFTP(t)
+	tempDir := t.TempDir()
+	data := filepath.Join(tempDir, "data")
+	files := map[string]string{"aaa_1.txt": "one", "1.txt": "taken", filepath.Join("sub", "aaa_2.txt"): "two"}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(data, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		createTempFile(t, data, name, content)
+	}
+
+	cfg := config{options: fileOptions{path: data, str: "aaa_"}, fsys: s}
+	result, err := walker(context.Background(), cfg, nil)
+	if err != nil {
+		t.Fatalf("walker error: %v", err)
+	}
+	opts := actionOptions{order: result.order, fsys: s}
+	if n, err := renameAction(context.Background(), result.pairs, opts); err != nil || n != 2 {
+		t.Fatalf("expected 2 files renamed, got %d and %v", n, err)
+	}
+	for name, content := range map[string]string{"1.txt": "taken", "1_1.txt": "one", "sub/2.txt": "two"} {
+		if got, err := os.ReadFile(filepath.Join(data, name)); err != nil || string(got) != content {
+			t.Errorf("%s: expected %q, got %q and %v", name, content, got, err)
+		}
+	}
+
+	out := filepath.Join(tempDir, "out")
+	opts = actionOptions{verify: true, fsys: s}
+	pairs := map[string]string{filepath.Join(data, "1_1.txt"): filepath.Join(out, "one.txt")}
+	if n, err := copyAction(context.Background(), pairs, opts); err != nil || n != 1 {
+		t.Fatalf("expected 1 file copied, got %d and %v", n, err)
+	}
+	before, err := os.Stat(filepath.Join(data, "sub", "2.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs = map[string]string{filepath.Join(data, "sub", "2.txt"): filepath.Join(out, "two.txt")}
+	if n, err := moveAction(context.Background(), pairs, opts); err != nil || n != 1 {
+		t.Fatalf("expected 1 file moved, got %d and %v", n, err)
+	}
+	for name, content := range map[string]string{"one.txt": "one", "two.txt": "two"} {
+		if got, err := os.ReadFile(filepath.Join(out, name)); err != nil || string(got) != content {
+			t.Errorf("%s: expected %q, got %q and %v", name, content, got, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(data, "sub", "2.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the moved file to be gone, got %v", err)
+	}
+	if after, err := os.Stat(filepath.Join(out, "two.txt")); err != nil || !os.SameFile(before, after) {
+		t.Errorf("expected the file to be moved by the host, not copied, got %v", err)
+	}
+}
+
+// TestDialSFTP verifies logging in with a key from ~/.ssh to a host in known_hosts.
+func TestDialSFTP(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(userKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startSSHHost(t, home, userKey)
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := dialSFTP("alice@" + addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer s.client.Close()
+	path := createTempFile(t, t.TempDir(), "file.txt", "remote")
+	if info, err := s.Stat(path); err != nil || info.Size() != int64(len("remote")) {
+		t.Errorf("expected to stat %s over sftp, got %v and %v", path, info, err)
+	}
+}
+
+// TestDialSFTPWithAgent verifies logging in with a key of the SSH agent, which is let go once logged in.
+func TestDialSFTPWithAgent(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startSSHHost(t, home, userKey)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: userKey}); err != nil {
+		t.Fatal(err)
+	}
+	// Unix socket paths are short, so the socket isn't put in t.TempDir.
+	sockDir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(sockDir) })
+	sock := filepath.Join(sockDir, "sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+	served := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		agent.ServeAgent(keyring, conn)
+		close(served)
+	}()
+
+	s, err := dialSFTP("alice@" + addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer s.client.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the agent connection to be closed once logged in")
+	}
+}
+
+// startSSHHost serves SFTP on a local port to alice, logging in with
+// userKey, and adds the host to the known_hosts of home. It returns the
+// host:port.
+func startSSHHost(t *testing.T, home string, userKey ed25519.PrivateKey) string {
+	t.Helper()
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	userSigner, err := ssh.NewSignerFromKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	addr := listener.Addr().String()
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "alice" && string(key.Marshal()) == string(userSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+	go serveSFTP(listener, serverConfig)
+	return addr
+}
+
+// serveSFTP accepts one SSH connection and serves the sftp subsystem on it.
+func serveSFTP(listener net.Listener, config *ssh.ServerConfig) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if server, err := sftp.NewServer(channel); err == nil {
+						go func() {
+							server.Serve()
+							channel.Close()
+						}()
+					}
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// isSparse reports whether the file takes fewer blocks than its size needs,
+// so it has holes.
+func isSparse(info fs.FileInfo) bool {
+	return false
+}
+
+func sparseCopy(ctx context.Context, out, in *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCopySparseFile verifies the holes of a sparse file survive the copy.
+func TestCopySparseFile(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "disk.img")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 16 << 20
+	if _, err := f.WriteAt([]byte("boot sector"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("middle"), size/2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSparse(info) {
+		t.Skip("the file system doesn't keep holes")
+	}
+
+	dst := filepath.Join(tempDir, "copy.img")
+	if err := copyFile(context.Background(), src, dst, actionOptions{reflink: NEVER}); err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+	want, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("expected the copy to match the source")
+	}
+	info, err = os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSparse(info) {
+		t.Error("expected the copy to keep the holes")
+	}
+}
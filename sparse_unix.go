@@ -0,0 +1,57 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isSparse reports whether the file takes fewer blocks than its size needs,
+// so it has holes.
+func isSparse(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < info.Size()
+}
+
+// sparseCopy copies only the data segments of in, found by SEEK_DATA and
+// SEEK_HOLE, and leaves the holes between them unwritten in out. It returns
+// errors.ErrUnsupported, before writing anything, if the file system can't
+// tell where the holes are.
+func sparseCopy(ctx context.Context, out, in *os.File, size int64) error {
+	for offset := int64(0); offset < size; {
+		data, err := in.Seek(offset, unix.SEEK_DATA)
+		switch {
+		case errors.Is(err, unix.ENXIO):
+			// The rest of the file is a hole.
+			offset = size
+			continue
+		case errors.Is(err, unix.EINVAL) && offset == 0:
+			return errors.ErrUnsupported
+		case err != nil:
+			return err
+		}
+		hole, err := in.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return err
+		}
+		if _, err := in.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := out.Seek(data, io.SeekStart); err != nil {
+			return err
+		}
+		// Hide ReadFrom, which may fill the holes it copies over.
+		if _, err := io.CopyN(struct{ io.Writer }{out}, contextReader{ctx, in}, hole-data); err != nil {
+			return err
+		}
+		offset = hole
+	}
+	return out.Truncate(size)
+}
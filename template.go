@@ -0,0 +1,208 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// nameTemplate builds a name out of literal text and {variable} or
+// {variable:argument} placeholders. Braces are escaped by doubling them.
+type nameTemplate struct {
+	segments []templateSegment
+	// hashes are the digests the template reads.
+	hashes []string
+}
+
+type templateSegment struct {
+	literal  string
+	variable string
+	arg      string
+}
+
+func parseTemplate(text string) (*nameTemplate, error) {
+	t := &nameTemplate{}
+	var literal strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "{{"), strings.HasPrefix(text[i:], "}}"):
+			literal.WriteByte(text[i])
+			i++
+		case text[i] == '}':
+			return nil, fmt.Errorf("unexpected } at %d", i)
+		case text[i] == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { at %d", i)
+			}
+			variable, arg, _ := strings.Cut(text[i+1:i+end], ":")
+			if err := t.check(variable, arg); err != nil {
+				return nil, err
+			}
+			if literal.Len() > 0 {
+				t.segments = append(t.segments, templateSegment{literal: literal.String()})
+				literal.Reset()
+			}
+			t.segments = append(t.segments, templateSegment{variable: variable, arg: arg})
+			i += end
+		default:
+			literal.WriteByte(text[i])
+		}
+	}
+	if literal.Len() > 0 {
+		t.segments = append(t.segments, templateSegment{literal: literal.String()})
+	}
+	return t, nil
+}
+
+// check validates a variable and records what it needs from the file.
+func (t *nameTemplate) check(variable, arg string) error {
+	switch variable {
+	case "name", "stem", "ext", "make", "model", "camera":
+		return nil
+	case "artist", "albumartist", "album", "title", "genre", "year", "author":
+		return nil
+	case "track", "disc":
+		if strings.Trim(arg, "0123456789") != "" {
+			return fmt.Errorf("{%s:%s}: padding must be a number, like 02", variable, arg)
+		}
+		return nil
+	case "date", "mtime":
+		if strings.ContainsAny(arg, `/\`) {
+			return fmt.Errorf("{%s:%s}: layout can't hold a path separator", variable, arg)
+		}
+		return nil
+	case SHA256, BLAKE3, XXHASH:
+		if arg != "" {
+			if n, err := strconv.Atoi(arg); err != nil || n < 1 {
+				return fmt.Errorf("{%s:%s}: length must be a positive number", variable, arg)
+			}
+		}
+		t.hashes = addHash(t.hashes, variable)
+		return nil
+	default:
+		return fmt.Errorf("unknown variable {%s}", variable)
+	}
+}
+
+// execute renders the template for the current name of the source.
+func (t *nameTemplate) execute(name string, src *source) string {
+	var b strings.Builder
+	for _, s := range t.segments {
+		if s.variable == "" {
+			b.WriteString(s.literal)
+			continue
+		}
+		b.WriteString(t.resolve(s, name, src))
+	}
+	return b.String()
+}
+
+func (t *nameTemplate) resolve(s templateSegment, name string, src *source) string {
+	ext := filepath.Ext(name)
+	switch s.variable {
+	case "name":
+		return name
+	case "stem":
+		return strings.TrimSuffix(name, ext)
+	case "ext":
+		return ext
+	case SHA256, BLAKE3, XXHASH:
+		digest := src.digests[s.variable]
+		if n, _ := strconv.Atoi(s.arg); n > 0 && n < len(digest) {
+			digest = digest[:n]
+		}
+		return digest
+	case "date":
+		taken, ok := src.created()
+		if !ok {
+			src.lack(s.variable)
+			taken = src.modTime()
+		}
+		return taken.Format(cmp.Or(s.arg, defaultDateLayout))
+	case "mtime":
+		return src.modTime().Format(cmp.Or(s.arg, defaultDateLayout))
+	case "track", "disc":
+		tags, _ := src.audio()
+		n := tags.track
+		if s.variable == "disc" {
+			n = tags.disc
+		}
+		if n == 0 {
+			src.lack(s.variable)
+		}
+		width, _ := strconv.Atoi(s.arg)
+		return fmt.Sprintf("%0*d", width, n)
+	case "make", "model", "camera", "artist", "albumartist", "album", "title", "genre", "year", "author":
+		value := strings.TrimSpace(metadataValue(s.variable, src))
+		if value == "" {
+			src.lack(s.variable)
+		}
+		return pathSafe(cmp.Or(value, s.arg))
+	default:
+		return ""
+	}
+}
+
+// metadataValue returns a text variable read from the photo, song or
+// document, or an empty string if the file doesn't have it.
+func metadataValue(variable string, src *source) string {
+	switch variable {
+	case "make", "model", "camera":
+		meta, _ := src.media()
+		switch variable {
+		case "make":
+			return meta.make
+		case "model":
+			return meta.model
+		default:
+			return cameraName(meta.make, meta.model)
+		}
+	case "author":
+		doc, _ := src.document()
+		return doc.author
+	case "title":
+		if tags, _ := src.audio(); tags.title != "" {
+			return tags.title
+		}
+		doc, _ := src.document()
+		return doc.title
+	}
+
+	tags, _ := src.audio()
+	switch variable {
+	case "artist":
+		return tags.artist
+	case "albumartist":
+		return cmp.Or(tags.albumArtist, tags.artist)
+	case "album":
+		return tags.album
+	case "genre":
+		return tags.genre
+	case "year":
+		if tags.year > 0 {
+			return strconv.Itoa(tags.year)
+		}
+	}
+	return ""
+}
+
+// defaultDateLayout is used by the date variables when no layout is given.
+const defaultDateLayout = "2006-01-02"
+
+// cameraName joins the make and the model without spaces, leaving out the
+// make when the model already starts with it, as in "CanonEOS5D".
+func cameraName(maker, model string) string {
+	name := model
+	if !strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		name = maker + model
+	}
+	return strings.ReplaceAll(name, " ", "")
+}
+
+// pathSafe keeps values read from the file from adding path elements.
+func pathSafe(value string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(value)
+}
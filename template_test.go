@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+// TestParseTemplate verifies templates render their variables and escapes.
+func TestParseTemplate(t *testing.T) {
+	src := &source{path: "photo.jpg", digests: map[string]string{SHA256: "abcdef0123"}}
+	cases := map[string]string{
+		"{stem}_{sha256:4}{ext}": "photo_abcd.jpg",
+		"{name}":                 "photo.jpg",
+		"{{{stem}}}{ext}":        "{photo}.jpg",
+		"fixed":                  "fixed",
+		"{sha256:99}":            "abcdef0123",
+	}
+	for text, expected := range cases {
+		tmpl, err := parseTemplate(text)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", text, err)
+			continue
+		}
+		if got := tmpl.execute("photo.jpg", src); got != expected {
+			t.Errorf("%q: expected %q, got %q", text, expected, got)
+		}
+	}
+}
+
+// TestParseTemplateInvalid verifies malformed templates are rejected.
+func TestParseTemplateInvalid(t *testing.T) {
+	for _, text := range []string{"{stem", "stem}", "{unknown}", "{sha256:x}", "{blake3:0}"} {
+		if _, err := parseTemplate(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+// TestTemplateHashes verifies templates record the digests they read.
+func TestTemplateHashes(t *testing.T) {
+	tmpl, err := parseTemplate("{blake3:8}-{xxhash}-{blake3}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpl.hashes) != 2 || tmpl.hashes[0] != BLAKE3 || tmpl.hashes[1] != XXHASH {
+		t.Errorf("expected [blake3 xxhash], got %v", tmpl.hashes)
+	}
+}
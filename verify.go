@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// verifyHash is the checksum copies are verified with.
+const verifyHash = BLAKE3
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyCopy hashes src and its copy at dst. On a mismatch it copies again,
+// up to opts.retries times, and if they still differ, removes dst and fails.
+// A copy left unchecked because ctx ended is removed too.
+func verifyCopy(ctx context.Context, src, dst string, opts actionOptions) error {
+	for attempt := 0; ; attempt++ {
+		err := compareChecksums(ctx, orOS(opts.fsys), src, dst)
+		if err == nil || !errors.Is(err, errChecksumMismatch) && ctx.Err() == nil {
+			return err
+		}
+		if attempt == opts.retries || ctx.Err() != nil {
+			if rmErr := orOS(opts.fsys).Remove(dst); rmErr != nil {
+				return errors.Join(err, fmt.Errorf("remove bad copy: %w", rmErr))
+			}
+			return err
+		}
+		// dst is the copy just made, so it is fine to write over it.
+		opts.overwrite = true
+		if err := copyFile(ctx, src, dst, opts); err != nil {
+			return fmt.Errorf("copy again after checksum mismatch: %w", err)
+		}
+	}
+}
+
+func compareChecksums(ctx context.Context, fsys fileSystem, src, dst string) error {
+	want, err := hashFile(ctx, fsys, src, []string{verifyHash})
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	got, err := hashFile(ctx, fsys, dst, []string{verifyHash})
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if got[verifyHash] != want[verifyHash] {
+		return fmt.Errorf("verify: %w: %s %s, copy %s",
+			errChecksumMismatch, verifyHash, want[verifyHash], got[verifyHash])
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestVerifyCopyRetries verifies a bad copy is made again, or removed once the retries run out.
+func TestVerifyCopyRetries(t *testing.T) {
+	tempDir := t.TempDir()
+	src := createTempFile(t, tempDir, "src.bin", "the original data")
+
+	dst := createTempFile(t, tempDir, "retried.bin", "the original dat4")
+	if err := verifyCopy(context.Background(), src, dst, actionOptions{retries: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "the original data" {
+		t.Errorf("expected the copy to be made again, got %q", data)
+	}
+
+	dst = createTempFile(t, tempDir, "failed.bin", "the original dat4")
+	if err := verifyCopy(context.Background(), src, dst, actionOptions{}); !errors.Is(err, errChecksumMismatch) {
+		t.Fatalf("expected %v, got %v", errChecksumMismatch, err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("expected the bad copy to be removed, got %v", err)
+	}
+}
+
+// TestMoveActionWithVerify verifies moves remove the source once the copy checks out.
+func TestMoveActionWithVerify(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	src := createTempFile(t, srcDir, "archive.tar", "archived content")
+	dst := filepath.Join(dstDir, "archive.tar")
+
+	n, err := moveAction(context.Background(), map[string]string{src: dst}, actionOptions{verify: true})
+	if err != nil {
+		t.Fatalf("move error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 file moved, got %d", n)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("expected the source to be removed, got %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "archived content" {
+		t.Errorf("expected the content to be moved, got %q", data)
+	}
+}